@@ -0,0 +1,470 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"go/version"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// stubSources holds stubs of the API of the storage library and its
+// dependencies, as stubs/<import path>/*.go.stub. They only declare
+// what the generated servers use, so they can be type-checked without
+// downloading any module.
+//
+//go:embed stubs
+var stubSources embed.FS
+
+// maxCheckErrors is the maximum number of errors reported by a check.
+const maxCheckErrors = 10
+
+// stubGapRegexes match the type errors caused by using a member of a
+// stubbed package which the stubs do not declare. They capture the
+// package, the member and, for fields and methods, the type.
+var stubGapRegexes = []*regexp.Regexp{
+	regexp.MustCompile(`^undefined: (?P<package>\w+)\.(?P<member>\w+)$`),
+	regexp.MustCompile(`\(type \*?(?P<package>\w+)\.(?P<type>\w+) has no field or method (?P<member>\w+)`),
+	regexp.MustCompile(`^unknown field (?P<member>\w+) in struct literal of type (?P<package>\w+)\.(?P<type>\w+)$`),
+}
+
+// errNotStubbed tells an imported package is neither in the standard
+// library nor among the stubs.
+var errNotStubbed = errors.New("package not covered by the API stubs")
+
+// stubImporter imports the local packages of the server from their
+// sources, the stubbed packages from their embedded sources, and the
+// standard library from GOROOT.
+type stubImporter struct {
+	fset *token.FileSet
+	std  types.Importer
+	// config is the config to type-check the local packages with.
+	config *types.Config
+	// modulePath is the module path of the server.
+	modulePath string
+	// local are the files of the local packages, by import path.
+	local    map[string][]*ast.File
+	packages map[string]*types.Package
+}
+
+// isStdPackage tells whether an import path belongs to the standard library.
+func isStdPackage(importPath string) bool {
+	first, _, _ := strings.Cut(importPath, "/")
+	return !strings.Contains(first, ".")
+}
+
+// isStubbedPackage tells whether an import path is among the stubs.
+func isStubbedPackage(importPath string) bool {
+	entries, err := stubSources.ReadDir(path.Join("stubs", importPath))
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(entries, func(entry fs.DirEntry) bool {
+		return strings.HasSuffix(entry.Name(), ".go.stub")
+	})
+}
+
+// isLocalPackage tells whether an import path belongs to the server.
+func (importer *stubImporter) isLocalPackage(importPath string) bool {
+	return importer.modulePath != "" && strings.HasPrefix(importPath, importer.modulePath+"/")
+}
+
+// Import imports a package by its path.
+func (importer *stubImporter) Import(importPath string) (*types.Package, error) {
+	if pkg, ok := importer.packages[importPath]; ok {
+		if pkg == nil {
+			return nil, fmt.Errorf("import cycle through %s", importPath)
+		}
+		return pkg, nil
+	}
+
+	var pkg *types.Package
+	var err error
+	switch {
+	case importer.isLocalPackage(importPath):
+		if files, ok := importer.local[importPath]; ok {
+			// A nil entry tells the package is being checked. Its
+			// errors are reported through the config.
+			importer.packages[importPath] = nil
+			pkg, _ = importer.config.Check(importPath, importer.fset, files, nil)
+		} else {
+			err = fmt.Errorf("package %s is not in the server", importPath)
+		}
+	case isStubbedPackage(importPath):
+		pkg, err = importer.importStub(importPath)
+	case isStdPackage(importPath):
+		pkg, err = importer.std.Import(importPath)
+	default:
+		err = errNotStubbed
+	}
+	if err != nil {
+		return nil, err
+	}
+	importer.packages[importPath] = pkg
+	return pkg, nil
+}
+
+// importStub parses and type-checks a stubbed package.
+func (importer *stubImporter) importStub(importPath string) (*types.Package, error) {
+	directory := path.Join("stubs", importPath)
+	entries, err := stubSources.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []*ast.File{}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".go.stub") {
+			continue
+		}
+		content, err := stubSources.ReadFile(path.Join(directory, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		file, err := parser.ParseFile(importer.fset, path.Join(directory, entry.Name()), content, 0)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	config := types.Config{Importer: importer}
+	return config.Check(importPath, importer.fset, files, nil)
+}
+
+// memberNames lists the names of the fields and methods of a type.
+func memberNames(typ types.Type) []string {
+	names := []string{}
+	if structType, ok := typ.Underlying().(*types.Struct); ok {
+		for index := range structType.NumFields() {
+			names = append(names, structType.Field(index).Name())
+		}
+	}
+	methods := types.NewMethodSet(typ)
+	if !types.IsInterface(typ) {
+		methods = types.NewMethodSet(types.NewPointer(typ))
+	}
+	for index := range methods.Len() {
+		names = append(names, methods.At(index).Obj().Name())
+	}
+	return names
+}
+
+// editDistance tells how many single-character edits turn a string
+// into another one.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+// stubGap tells whether a type error comes from using a member of a
+// stubbed package which the stubs do not declare. If so, it also tells
+// the declared member with a name so similar that the used one is most
+// likely a typo, if any.
+func stubGap(typeErr types.Error, stubbed map[string]*types.Package) (bool, string) {
+	for _, regex := range stubGapRegexes {
+		match := regex.FindStringSubmatch(typeErr.Msg)
+		if match == nil {
+			continue
+		}
+		pkg := stubbed[match[regex.SubexpIndex("package")]]
+		if pkg == nil {
+			return false, ""
+		}
+		member := match[regex.SubexpIndex("member")]
+		names := []string{}
+		if index := regex.SubexpIndex("type"); index < 0 {
+			names = pkg.Scope().Names()
+		} else if object := pkg.Scope().Lookup(match[index]); object != nil {
+			names = memberNames(object.Type())
+		}
+		for _, name := range names {
+			maxDistance := 1
+			if len(name) >= 6 {
+				maxDistance = 2
+			}
+			if editDistance(strings.ToLower(member), strings.ToLower(name)) <= maxDistance {
+				return true, name
+			}
+		}
+		return true, ""
+	}
+	return false, ""
+}
+
+var (
+	// moduleRegex matches the module directive of a go.mod file.
+	moduleRegex = regexp.MustCompile(`(?m)^module\s+(\S+)`)
+	// goDirectiveRegex matches the go directive of a go.mod file.
+	goDirectiveRegex = regexp.MustCompile(`(?m)^go\s+(\S+)`)
+)
+
+// isServerSource tells whether a file of the project (by its relative
+// path) is a Go source of the server to check.
+func isServerSource(filePath string) bool {
+	if !strings.HasSuffix(filePath, ".go") || strings.HasSuffix(filePath, "_test.go") {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(filePath), "/")
+	if parts[0] != "server" {
+		return false
+	}
+	for _, part := range parts[1 : len(parts)-1] {
+		if part == "vendor" || part == "testdata" || strings.HasPrefix(part, ".") || strings.HasPrefix(part, "_") {
+			return false
+		}
+	}
+	return true
+}
+
+// serverSources collects the Go sources of the server (including its
+// packages) and its go.mod: the planned ones, and the existing ones in
+// the project which are not planned (e.g. a main.go which is not being
+// regenerated).
+func serverSources(projectPath string, plan *filePlan) (map[string]string, error) {
+	sources := map[string]string{}
+	isSource := func(filePath string) bool {
+		return filePath == filepath.Join("server", "go.mod") || isServerSource(filePath)
+	}
+	serverPath := filepath.Join(projectPath, "server")
+	err := filepath.WalkDir(serverPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(projectPath, filePath)
+		if err != nil || !entry.Type().IsRegular() || !isSource(relative) {
+			return err
+		}
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		sources[relative] = string(content)
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, &PathError{"read server sources", serverPath, err}
+	}
+	for _, file := range plan.files {
+		if isSource(file.path) {
+			sources[file.path] = file.contents
+		}
+	}
+	return sources, nil
+}
+
+// checkServeCalls tells whether the main package of the server calls
+// serve and trackClient, when the generated serve.go defines them. The
+// stack relies on them: without them, /readyz never tells the server
+// is ready, so its healthchecks and probes never pass.
+func checkServeCalls(projectPath string, plan *filePlan) error {
+	sources, err := serverSources(projectPath, plan)
+	if err != nil {
+		return err
+	}
+	servePath := filepath.Join("server", "serve.go")
+	if _, ok := sources[servePath]; !ok {
+		return nil
+	}
+
+	called := map[string]bool{}
+	fset := token.NewFileSet()
+	for name, source := range sources {
+		if filepath.Dir(name) != "server" || name == servePath {
+			continue
+		}
+		// Syntax errors are reported by the check of the server.
+		file, err := parser.ParseFile(fset, name, source, 0)
+		if err != nil {
+			continue
+		}
+		ast.Inspect(file, func(node ast.Node) bool {
+			if call, ok := node.(*ast.CallExpr); ok {
+				if ident, ok := call.Fun.(*ast.Ident); ok {
+					called[ident.Name] = true
+				}
+			}
+			return true
+		})
+	}
+	missing := []string{}
+	for _, name := range []string{"serve", "trackClient"} {
+		if !called[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf(
+			"%w: the app must call serve and trackClient, as the builtin templates do, or the server never tells it is ready and its healthchecks and probes never pass (not called: %s)",
+			ErrInvalidSpec, strings.Join(missing, ", "),
+		)
+	}
+	return nil
+}
+
+// checkErrors builds the error of a failed check, out of the errors
+// found in the sources.
+func checkErrors(messages []string) error {
+	if len(messages) > maxCheckErrors {
+		messages = append(messages[:maxCheckErrors], fmt.Sprintf("(and %d more errors)", len(messages)-maxCheckErrors))
+	}
+	return fmt.Errorf(
+		"%w: the generated server does not compile (use -skipCheck to write it anyway):\n  %s",
+		ErrInvalidSpec, strings.Join(messages, "\n  "),
+	)
+}
+
+// checkServer parses and type-checks the Go sources of the server the
+// plan would leave in the project, reporting line-accurate errors. The
+// storage library and its dependencies are checked against stubs of
+// their API. If the sources import something else, or the standard
+// library is not available, only the syntax is checked.
+func checkServer(projectPath string, plan *filePlan) error {
+	sources, err := serverSources(projectPath, plan)
+	if err != nil {
+		return err
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+
+	// First, parse the sources, grouped by package. The ones at the
+	// root of the server are the main package.
+	modulePath, goVersion := "", "go1.22"
+	if match := moduleRegex.FindStringSubmatch(sources[filepath.Join("server", "go.mod")]); match != nil {
+		modulePath = match[1]
+	}
+	if match := goDirectiveRegex.FindStringSubmatch(sources[filepath.Join("server", "go.mod")]); match != nil {
+		goVersion = "go" + match[1]
+	}
+	delete(sources, filepath.Join("server", "go.mod"))
+	if len(sources) == 0 {
+		return nil
+	}
+	fset := token.NewFileSet()
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	parsed := []*ast.File{}
+	files := []*ast.File{}
+	local := map[string][]*ast.File{}
+	messages := []string{}
+	for _, name := range names {
+		file, err := parser.ParseFile(fset, filepath.ToSlash(name), sources[name], parser.AllErrors)
+		var list scanner.ErrorList
+		if errors.As(err, &list) {
+			for _, item := range list {
+				messages = append(messages, item.Error())
+			}
+		} else if err != nil {
+			messages = append(messages, err.Error())
+		}
+		parsed = append(parsed, file)
+		if directory := filepath.ToSlash(filepath.Dir(name)); directory == "server" {
+			files = append(files, file)
+		} else {
+			importPath := modulePath + strings.TrimPrefix(directory, "server")
+			local[importPath] = append(local[importPath], file)
+		}
+	}
+	if len(messages) > 0 {
+		return checkErrors(messages)
+	}
+
+	if toolchain := runtime.Version(); version.IsValid(toolchain) && version.Compare(goVersion, toolchain) > 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "Warning: only the syntax of the server was checked: %s is newer than the generator's %s\n", goVersion, toolchain)
+		return nil
+	}
+
+	// Then, load the imported packages. Unknown ones disable the
+	// type-check, since it would tell false errors.
+	config := &types.Config{GoVersion: goVersion}
+	imports := &stubImporter{
+		fset, importer.ForCompiler(fset, "source", nil), config, modulePath, local, map[string]*types.Package{},
+	}
+	for _, file := range parsed {
+		for _, spec := range file.Imports {
+			importPath, _ := strconv.Unquote(spec.Path.Value)
+			if imports.isLocalPackage(importPath) {
+				continue
+			}
+			_, err := imports.Import(importPath)
+			if errors.Is(err, errNotStubbed) {
+				_, _ = fmt.Fprintf(os.Stderr, "Warning: only the syntax of the server was checked: %s is not covered by the API stubs\n", importPath)
+				return nil
+			} else if err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "Warning: only the syntax of the server was checked: could not load %s: %s\n", importPath, err)
+				return nil
+			}
+		}
+	}
+
+	// Finally, type-check the sources (the local packages are checked
+	// as they are imported). Errors are reported in the order of the
+	// sources, not in the order they were found. The stubs only declare
+	// part of the API, so using something else of a stubbed package is
+	// just a warning, unless it is most likely a typo.
+	stubbed := map[string]*types.Package{}
+	for importPath, pkg := range imports.packages {
+		if pkg != nil && isStubbedPackage(importPath) {
+			stubbed[pkg.Name()] = pkg
+		}
+	}
+	typeErrors := []types.Error{}
+	config.Importer = imports
+	config.Error = func(err error) {
+		typeErr := err.(types.Error)
+		if gap, similar := stubGap(typeErr, stubbed); gap && similar == "" {
+			_, _ = fmt.Fprintf(os.Stderr, "Warning: %s (not covered by the API stubs, so left unchecked)\n", typeErr.Error())
+			return
+		} else if gap {
+			typeErr.Msg += fmt.Sprintf(" (did you mean %s?)", similar)
+		}
+		typeErrors = append(typeErrors, typeErr)
+	}
+	_, _ = config.Check("main", fset, files, nil)
+	for importPath := range local {
+		_, _ = imports.Import(importPath)
+	}
+	slices.SortStableFunc(typeErrors, func(a, b types.Error) int {
+		return int(a.Pos) - int(b.Pos)
+	})
+	for _, typeErr := range typeErrors {
+		messages = append(messages, typeErr.Error())
+	}
+	if len(messages) > 0 {
+		return checkErrors(messages)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// checkedModule is the go.mod of the servers checked by the tests.
+const checkedModule = "module example.com/server\n\ngo 1.22\n"
+
+func TestCheckServer(t *testing.T) {
+	cases := []struct {
+		name string
+		main string
+		// want is a substring of the error, or empty if none is expected.
+		want string
+	}{
+		{"valid", `package main
+
+import "github.com/AlephVault/golang-standard-http-mongodb-storage/core/dsl"
+
+var resources = map[string]dsl.Resource{
+	"heroes": {Type: dsl.ListResource, ListMaxResults: 20, TableRef: dsl.TableRef{Db: "game", Collection: "heroes"}},
+}
+
+func main() { _ = resources }
+`, ""},
+		{"syntax error", `package main
+
+func main() {
+`, "expected '}'"},
+		{"local type error", `package main
+
+func main() { var count int = "one"; _ = count }
+`, "cannot use \"one\""},
+		{"misspelled stubbed identifier", `package main
+
+import "github.com/AlephVault/golang-standard-http-mongodb-storage/core/dsl"
+
+var resource = dsl.Resource{Type: dsl.ListResourse}
+
+func main() { _ = resource }
+`, "undefined: dsl.ListResourse (did you mean ListResource?)"},
+		{"misspelled stubbed field", `package main
+
+import "github.com/AlephVault/golang-standard-http-mongodb-storage/core/dsl"
+
+var resource = dsl.Resource{ListMaxResult: 20}
+
+func main() { _ = resource }
+`, "unknown field ListMaxResult in struct literal of type dsl.Resource (did you mean ListMaxResults?)"},
+		{"misspelled stubbed method", `package main
+
+import "github.com/AlephVault/golang-standard-http-mongodb-storage/app"
+
+func main() { var application *app.Application; application.Runn() }
+`, "has no field or method Runn) (did you mean Run?)"},
+		{"member not covered by the stubs", `package main
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func main() {
+	var collection *mongo.Collection
+	_, _ = collection.Aggregate(context.Background(), bson.A{})
+}
+`, ""},
+	}
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			plan := &filePlan{}
+			plan.add(filepath.Join("server", "go.mod"), checkedModule, 0644)
+			plan.add(filepath.Join("server", "main.go"), testCase.main, 0644)
+
+			err := checkServer(t.TempDir(), plan)
+			if testCase.want == "" {
+				if err != nil {
+					t.Fatalf("checkServer: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidSpec) {
+				t.Fatalf("checkServer: got %v, want %v", err, ErrInvalidSpec)
+			}
+			if !strings.Contains(err.Error(), testCase.want) {
+				t.Errorf("checkServer: %q does not mention %q", err, testCase.want)
+			}
+		})
+	}
+}
+
+func TestIsServerSource(t *testing.T) {
+	cases := []struct {
+		path string
+		want bool
+	}{
+		{"server/main.go", true},
+		{"server/models/models.go", true},
+		{"server/main_test.go", false},
+		{"server/go.mod", false},
+		{"server/vendor/example.com/lib/lib.go", false},
+		{"server/testdata/main.go", false},
+		{"server/.cache/main.go", false},
+		{"server/_old/main.go", false},
+		{"proxy/main.go", false},
+	}
+	for _, testCase := range cases {
+		if got := isServerSource(filepath.FromSlash(testCase.path)); got != testCase.want {
+			t.Errorf("isServerSource(%q) = %v, want %v", testCase.path, got, testCase.want)
+		}
+	}
+}
+
+func TestCheckServeCalls(t *testing.T) {
+	cases := []struct {
+		name string
+		// serve tells whether the plan has the generated serve.go.
+		serve bool
+		main  string
+		// want is a substring of the error, or empty if none is expected.
+		want string
+	}{
+		{"both called", true, "package main\n\nfunc main() { trackClient(nil); _ = serve(nil, nil, \":80\") }\n", ""},
+		{"none called", true, "package main\n\nfunc main() { _ = application.Run(\":80\") }\n", "not called: serve, trackClient"},
+		{"serve not called", true, "package main\n\nfunc main() { trackClient(nil) }\n", "not called: serve"},
+		{"without serve.go", false, "package main\n\nfunc main() {}\n", ""},
+	}
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			plan := &filePlan{}
+			plan.add(filepath.Join("server", "main.go"), testCase.main, 0644)
+			if testCase.serve {
+				plan.add(filepath.Join("server", "serve.go"), "package main\n\nfunc serve() { trackClient(nil) }\n", 0644)
+			}
+
+			err := checkServeCalls(t.TempDir(), plan)
+			if testCase.want == "" {
+				if err != nil {
+					t.Fatalf("checkServeCalls: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidSpec) || !strings.Contains(err.Error(), testCase.want) {
+				t.Errorf("checkServeCalls: got %v, want an invalid spec error about %q", err, testCase.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,620 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/AlephVault/golang-windrose-http-storage-generator/cmd/generator/templates"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// command stands for a generator subcommand.
+type command struct {
+	// name is the name used to invoke the command.
+	name string
+	// summary is the one-line description shown in the main help.
+	summary string
+	// run executes the command with its own arguments.
+	run func(name string, args []string) error
+}
+
+// commands lists all the available subcommands, in help order.
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"init", "Generates a brand-new project stack", runInit},
+		{"add-resource", "Adds a new resource to an existing project", runAddResource},
+		{"regenerate", "Regenerates the stack files of an existing project", runRegenerate},
+		{"doctor", "Checks the health of an existing project", runDoctor},
+		{"list-templates", "Lists the available app templates", runListTemplates},
+		{"describe-template", "Describes an app template", runDescribeTemplate},
+		{"install-template", "Installs a template pack into the template cache", runInstallTemplate},
+	}
+}
+
+// printUsage prints the general usage of the generator.
+func printUsage() {
+	_, _ = fmt.Fprintln(os.Stderr, "Usage: generator <command> [flags]")
+	_, _ = fmt.Fprintln(os.Stderr, "")
+	_, _ = fmt.Fprintln(os.Stderr, "Commands:")
+	width := 0
+	for _, command := range commands {
+		width = max(width, len(command.name))
+	}
+	for _, command := range commands {
+		_, _ = fmt.Fprintf(os.Stderr, "  %-*s  %s\n", width, command.name, command.summary)
+	}
+	_, _ = fmt.Fprintln(os.Stderr, "")
+	_, _ = fmt.Fprintln(os.Stderr, "Use \"generator help <command>\" for more information about a command.")
+}
+
+// findCommand looks for a command by its name.
+func findCommand(name string) *command {
+	for _, command := range commands {
+		if command.name == name {
+			return command
+		}
+	}
+	return nil
+}
+
+// runCommand dispatches the arguments to the proper command. For
+// compatibility, running with flags but no command stands for init.
+func runCommand(args []string) error {
+	if len(args) == 0 {
+		printUsage()
+		return fmt.Errorf("%w: no command given", ErrUsage)
+	}
+
+	if strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "-help" && args[0] != "--help" {
+		return runInit("init", args)
+	}
+
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		if len(args) > 1 {
+			if command := findCommand(args[1]); command != nil {
+				return command.run(command.name, []string{"-h"})
+			}
+			return fmt.Errorf("%w: unknown command %s", ErrUsage, args[1])
+		}
+		printUsage()
+		return nil
+	}
+
+	if command := findCommand(args[0]); command != nil {
+		return command.run(command.name, args[1:])
+	}
+	printUsage()
+	return fmt.Errorf("%w: unknown command %s", ErrUsage, args[0])
+}
+
+// newFlagSet creates the flag set of a command, with a usage
+// function describing the command.
+func newFlagSet(name, usage, description string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: generator %s %s\n\n", name, usage)
+		_, _ = fmt.Fprintln(os.Stderr, description)
+		_, _ = fmt.Fprintln(os.Stderr, "")
+		_, _ = fmt.Fprintln(os.Stderr, "Flags:")
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// requireFlags fails the command if any of the given flags is empty.
+func requireFlags(flags *flag.FlagSet, values map[string]string) error {
+	missing := []string{}
+	flags.VisitAll(func(f *flag.Flag) {
+		if value, ok := values[f.Name]; ok && value == "" {
+			missing = append(missing, f.Name)
+		}
+	})
+	if len(missing) > 0 {
+		flags.Usage()
+		return fmt.Errorf("%w: %s required", ErrUsage, strings.Join(missing, " and "))
+	}
+	return nil
+}
+
+// stringsFlag is a repeatable string flag.
+type stringsFlag []string
+
+// String returns the flag values, comma-separated.
+func (values *stringsFlag) String() string {
+	return strings.Join(*values, ",")
+}
+
+// Set adds a value to the flag.
+func (values *stringsFlag) Set(value string) error {
+	*values = append(*values, value)
+	return nil
+}
+
+// addSpecFlags defines the flags that make a project spec, and
+// returns a function that, once they're parsed, applies the flags
+// explicitly set on top of a base spec (or the defaults, if nil).
+func addSpecFlags(flags *flag.FlagSet) func(base *ProjectSpec) *ProjectSpec {
+	defaults := defaultProjectSpec()
+	template := flags.String("template", "", "Template to use (see list-templates, or a path to a file, directory or archive)")
+	profile := flags.String("profile", defaults.Profile, "Deployment profile: development or production (hardened, without debug)")
+	schema := flags.String("schema", "", "Path to a schema file (YAML or JSON) to generate resources from")
+	mongoDBPort := flags.Uint("mongoDBPort", uint(defaults.Mongo.Port), "MongoDB port to use")
+	httpPort := flags.Uint("httpPort", uint(defaults.HTTP.Port), "HTTP port to use")
+	mongoDBExpressPort := flags.Uint("mongoDBExpressPort", uint(defaults.MongoExpress.Port), "MongoDB Express port to use")
+	tls := flags.Bool("tls", false, "Add a reverse proxy that terminates TLS in front of the HTTP service (which is not published anymore)")
+	tlsPort := flags.Uint("tlsPort", uint(defaults.TLS.Port), "HTTPS port to use")
+	tlsHost := flags.String("tlsHost", defaults.TLS.Host, "Host name of the TLS certificate")
+	tlsCertFile := flags.String("tlsCertFile", "", "Path to the TLS certificate, relative to the project (default: self-signed, development only)")
+	tlsKeyFile := flags.String("tlsKeyFile", "", "Path to the TLS certificate's private key, relative to the project")
+	metrics := flags.Bool("metrics", false, "Serve Prometheus metrics from the server (in /metrics, on the unpublished port 9100)")
+	monitoring := flags.Bool("monitoring", false, "Add Prometheus and Grafana services, with a dashboard of the metrics (needs -metrics)")
+	prometheusPort := flags.Uint("prometheusPort", uint(defaults.Metrics.PrometheusPort), "Prometheus port to use (development profile only)")
+	grafanaPort := flags.Uint("grafanaPort", uint(defaults.Metrics.GrafanaPort), "Grafana port to use (bound to localhost in the production profile)")
+	grafanaPassword := flags.String("grafanaPassword", "", "Grafana admin password (default: randomly generated)")
+	mongoDBUser := flags.String("mongoDBUser", defaults.Mongo.User, "MongoDB user")
+	mongoDBPassword := flags.String("mongoDBPassword", "", "MongoDB password (default: randomly generated)")
+	replicaSetMembers := flags.Int("replicaSetMembers", defaults.Mongo.ReplicaSet.Members,
+		"Members of the MongoDB replica set (needed for transactions): 1, 3, or 0 for a standalone MongoDB")
+	replicaSetName := flags.String("replicaSetName", defaults.Mongo.ReplicaSet.Name, "Name of the MongoDB replica set")
+	defaultAPIKey := flags.String("defaultAPIKey", "", "Default server API key (default: randomly generated)")
+	module := flags.String("module", defaults.Server.Module, "Go module path of the server")
+	goVersion := flags.String("goVersion", defaults.Server.GoVersion, "Go version of the server (go.mod and builder image)")
+	storageVersion := flags.String("storageVersion", defaults.Server.StorageVersion, "Version of the storage library to pin")
+	dependencies := flags.String("dependencies", defaults.Server.Dependencies,
+		"How to resolve the server's dependencies: on build (tidy), on generation into go.sum (resolved), or also into vendor/ (vendor)")
+	shutdownTimeout := flags.String("shutdownTimeout", defaults.Server.ShutdownTimeout,
+		"How long the server waits for the in-flight requests on shutdown (e.g. 15s)")
+	kubernetes := flags.Bool("kubernetes", false, "Also generate Kubernetes manifests (in k8s/)")
+	helm := flags.Bool("helm", false, "Also generate a Helm chart (in helm/)")
+	kubernetesNamespace := flags.String("kubernetesNamespace", defaults.Kubernetes.Namespace, "Kubernetes namespace to deploy into (raw manifests)")
+	kubernetesImage := flags.String("kubernetesImage", defaults.Kubernetes.Image, "Image of the server to deploy into Kubernetes")
+	kubernetesReplicas := flags.Uint("kubernetesReplicas", uint(defaults.Kubernetes.Replicas), "Number of replicas of the server in Kubernetes")
+	mongoDBStorage := flags.String("mongoDBStorage", defaults.Kubernetes.StorageSize, "Size of the MongoDB volume in Kubernetes")
+	ingressHost := flags.String("ingressHost", "", "Host to route to the server through a Kubernetes Ingress (default: no Ingress)")
+	parameters := stringsFlag{}
+	flags.Var(&parameters, "param", "A parameter of the template, as name=value (repeatable)")
+
+	return func(base *ProjectSpec) *ProjectSpec {
+		spec := defaults
+		if base != nil {
+			spec = base
+		}
+		flags.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "template":
+				spec.Template = *template
+			case "profile":
+				spec.Profile = *profile
+			case "schema":
+				spec.Schema = *schema
+			case "mongoDBPort":
+				spec.Mongo.Port = uint16(*mongoDBPort)
+			case "httpPort":
+				spec.HTTP.Port = uint16(*httpPort)
+			case "mongoDBExpressPort":
+				spec.MongoExpress.Port = uint16(*mongoDBExpressPort)
+			case "tls":
+				spec.TLS.Enabled = *tls
+			case "tlsPort":
+				spec.TLS.Port = uint16(*tlsPort)
+			case "tlsHost":
+				spec.TLS.Host = *tlsHost
+			case "tlsCertFile":
+				spec.TLS.CertFile = *tlsCertFile
+			case "tlsKeyFile":
+				spec.TLS.KeyFile = *tlsKeyFile
+			case "metrics":
+				spec.Metrics.Enabled = *metrics
+			case "monitoring":
+				spec.Metrics.Services = *monitoring
+			case "prometheusPort":
+				spec.Metrics.PrometheusPort = uint16(*prometheusPort)
+			case "grafanaPort":
+				spec.Metrics.GrafanaPort = uint16(*grafanaPort)
+			case "grafanaPassword":
+				spec.Metrics.GrafanaPassword = *grafanaPassword
+			case "mongoDBUser":
+				spec.Mongo.User = *mongoDBUser
+			case "mongoDBPassword":
+				spec.Mongo.Password = *mongoDBPassword
+			case "replicaSetMembers":
+				spec.Mongo.ReplicaSet.Members = *replicaSetMembers
+			case "replicaSetName":
+				spec.Mongo.ReplicaSet.Name = *replicaSetName
+			case "defaultAPIKey":
+				spec.Server.APIKey = *defaultAPIKey
+			case "module":
+				spec.Server.Module = *module
+			case "goVersion":
+				spec.Server.GoVersion = *goVersion
+			case "storageVersion":
+				spec.Server.StorageVersion = *storageVersion
+			case "dependencies":
+				spec.Server.Dependencies = *dependencies
+			case "shutdownTimeout":
+				spec.Server.ShutdownTimeout = *shutdownTimeout
+			case "kubernetes":
+				spec.Kubernetes.Enabled = *kubernetes
+			case "helm":
+				spec.Kubernetes.Helm = *helm
+			case "kubernetesNamespace":
+				spec.Kubernetes.Namespace = *kubernetesNamespace
+			case "kubernetesImage":
+				spec.Kubernetes.Image = *kubernetesImage
+			case "kubernetesReplicas":
+				spec.Kubernetes.Replicas = uint16(*kubernetesReplicas)
+			case "mongoDBStorage":
+				spec.Kubernetes.StorageSize = *mongoDBStorage
+			case "ingressHost":
+				spec.Kubernetes.IngressHost = *ingressHost
+			case "param":
+				values := maps.Clone(spec.Parameters)
+				if values == nil {
+					values = map[string]string{}
+				}
+				for _, parameter := range parameters {
+					name, value, _ := strings.Cut(parameter, "=")
+					values[name] = value
+				}
+				spec.Parameters = values
+			}
+		})
+		return spec
+	}
+}
+
+// addWriteFlags defines the flags that tell how to write the files,
+// and returns a function that gets the write options once parsed.
+func addWriteFlags(flags *flag.FlagSet) func() writeOptions {
+	force := flags.Bool("force", false, "Overwrite existing files that would change")
+	backup := flags.Bool("backup", false, "Overwrite existing files that would change, keeping a backup of each")
+	dryRun := flags.Bool("dryRun", false, "Print the files that would be generated instead of writing them")
+	showContents := flags.Bool("showContents", false, "On dry runs, also print the contents of the files (with the secrets redacted)")
+	skipCheck := flags.Bool("skipCheck", false, "Do not compile-check the generated server before writing it")
+
+	return func() writeOptions {
+		options := writeOptions{mode: writeSafe, dryRun: *dryRun, showContents: *showContents, skipCheck: *skipCheck}
+		if *backup {
+			options.mode = writeBackup
+		} else if *force {
+			options.mode = writeForce
+		}
+		return options
+	}
+}
+
+// printSecretMessages prints the messages about generated secrets.
+// They are only shown this time, so they must be noted down. On dry
+// runs they are not shown (and they are redacted from the contents),
+// since they are not stored anywhere and the actual run generates
+// different ones.
+func printSecretMessages(messages []string, dryRun bool) {
+	if len(messages) == 0 {
+		return
+	} else if dryRun {
+		fmt.Println("Secrets not given would be randomly generated. They are not shown: nothing was written, so the actual run will generate different ones.")
+		return
+	}
+	for _, message := range messages {
+		fmt.Println(message)
+	}
+	fmt.Println("These secrets are stored in the project's .env file and will not be shown again.")
+}
+
+// runInit generates a brand-new project.
+func runInit(name string, args []string) error {
+	flags := newFlagSet(name, "-projectPath <path> (-template <template> | -manifest <file>) [flags]",
+		"Generates a brand-new project stack in the given path. The settings\n"+
+			"come from the manifest, if any, and the flags explicitly set. The\n"+
+			"resulting settings are stored in the project's "+manifestFileName+".\n"+
+			"Secrets not given are randomly generated, and shown once.\n"+
+			"Existing files that would change are not overwritten unless -force\n"+
+			"or -backup is set: a diff of the changes is printed instead. With\n"+
+			"-dryRun, the files are just listed (and printed, with -showContents).")
+	projectPath := flags.String("projectPath", "", "Path to the project (mandatory)")
+	manifestPath := flags.String("manifest", "", "Path to a manifest (YAML or JSON) to take the settings from")
+	makeSpec := addSpecFlags(flags)
+	getWriteOptions := addWriteFlags(flags)
+	_ = flags.Parse(args)
+
+	if err := requireFlags(flags, map[string]string{"projectPath": *projectPath}); err != nil {
+		return err
+	}
+	base := defaultProjectSpec()
+	if *manifestPath != "" {
+		var err error
+		if base, err = loadManifest(*manifestPath, base); err != nil {
+			return err
+		}
+	}
+	// Re-running over an existing project keeps its secrets.
+	if err := loadEnvSecrets(*projectPath, base); err != nil {
+		return err
+	}
+	spec := makeSpec(base)
+	if err := requireFlags(flags, map[string]string{"template": spec.Template}); err != nil {
+		return err
+	}
+	messages, err := fillSecrets(spec)
+	if err != nil {
+		return err
+	}
+	options := getWriteOptions()
+	options.secrets = secretValues(spec)
+	if err := generateProject(*projectPath, spec, options); err != nil {
+		return err
+	}
+	printSecretMessages(messages, options.dryRun)
+	return nil
+}
+
+// runAddResource adds a resource to the schema of an existing project.
+func runAddResource(name string, args []string) error {
+	flags := newFlagSet(name, "-projectPath <path> -name <resource> [-field Name:type[:validate]]... [flags]",
+		"Adds a new list resource, and its model, to the project's schema\n"+
+			"(creating "+schemaFileName+" if the project has none), and regenerates\n"+
+			"server/resources.go. The app template must merge extraResources.")
+	projectPath := flags.String("projectPath", "", "Path to the project (mandatory)")
+	resourceName := flags.String("name", "", "Name of the resource, as exposed in the API (mandatory)")
+	model := flags.String("model", "", "Name of the model type (default: derived from the resource name)")
+	db := flags.String("db", "", "Database of the resource's collection (default: \"universe\")")
+	collection := flags.String("collection", "", "Collection of the resource (default: the resource name)")
+	softDelete := flags.Bool("softDelete", true, "Whether the resource uses soft-delete")
+	listMaxResults := flags.Uint("listMaxResults", 0, "Maximum number of results per page (default: 20)")
+	fields := stringsFlag{}
+	flags.Var(&fields, "field", "A field of the model, as Name:type[:validate] (repeatable)")
+	_ = flags.Parse(args)
+
+	if err := requireFlags(flags, map[string]string{"projectPath": *projectPath, "name": *resourceName}); err != nil {
+		return err
+	}
+	resource := SchemaResource{
+		Name:           *resourceName,
+		Model:          *model,
+		Db:             *db,
+		Collection:     *collection,
+		SoftDelete:     softDelete,
+		ListMaxResults: *listMaxResults,
+	}
+	for _, field := range fields {
+		parts := strings.SplitN(field, ":", 3)
+		if len(parts) < 2 {
+			return fmt.Errorf("%w: invalid field %q: it must be Name:type[:validate]", ErrUsage, field)
+		}
+		schemaField := SchemaField{Name: parts[0], Type: parts[1]}
+		if len(parts) == 3 {
+			schemaField.Validate = parts[2]
+		}
+		resource.Fields = append(resource.Fields, schemaField)
+	}
+
+	spec, err := loadManifest(filepath.Join(*projectPath, manifestFileName), defaultProjectSpec())
+	if err != nil {
+		return err
+	}
+	plan := &filePlan{}
+	if spec.Schema == "" {
+		spec.Schema = filepath.Join(*projectPath, schemaFileName)
+		if err := makeManifestFile(plan, *projectPath, spec); err != nil {
+			return err
+		}
+	}
+	// The schema is written along with the files generated from it, so
+	// it is left as it was if they cannot be.
+	content, err := os.ReadFile(spec.Schema)
+	if err != nil && !os.IsNotExist(err) {
+		return &PathError{"read schema file", spec.Schema, err}
+	}
+	updated, err := addSchemaResource(spec.Schema, content, resource)
+	if err != nil {
+		return err
+	}
+	schema, err := parseSchema(spec.Schema, []byte(updated))
+	if err != nil {
+		return err
+	}
+	if err := planResourcesFile(plan, spec.Schema, schema); err != nil {
+		return err
+	}
+	plan.add(relativeToProject(*projectPath, spec.Schema), updated, 0644)
+	if err := resolveDependencies(*projectPath, plan, spec); err != nil {
+		return err
+	}
+	// These are generated files, so they are always overwritten.
+	return applyPlan(*projectPath, plan, writeOptions{mode: writeForce})
+}
+
+// runRegenerate regenerates the stack files of an existing project.
+func runRegenerate(name string, args []string) error {
+	flags := newFlagSet(name, "-projectPath <path> [flags]",
+		"Regenerates the stack files (compose, .env, Dockerfile, go.mod) of\n"+
+			"an existing project, using the settings from its "+manifestFileName+"\n"+
+			"and the secrets from its .env file. The flags explicitly set\n"+
+			"override them, and are stored in the manifest. The app files are\n"+
+			"only regenerated with -app. Files that would change are not\n"+
+			"overwritten unless -force or -backup is set: a diff of the\n"+
+			"changes is printed instead. With -dryRun, the files are just\n"+
+			"listed (and printed, with -showContents).")
+	projectPath := flags.String("projectPath", "", "Path to the project (mandatory)")
+	app := flags.Bool("app", false, "Also regenerate the app files (e.g. server/main.go) from the template")
+	makeSpec := addSpecFlags(flags)
+	getWriteOptions := addWriteFlags(flags)
+	_ = flags.Parse(args)
+
+	if err := requireFlags(flags, map[string]string{"projectPath": *projectPath}); err != nil {
+		return err
+	}
+	base, err := loadManifest(filepath.Join(*projectPath, manifestFileName), defaultProjectSpec())
+	if err != nil {
+		return err
+	}
+	if err := loadEnvSecrets(*projectPath, base); err != nil {
+		return err
+	}
+	spec := makeSpec(base)
+	if *app {
+		if err := requireFlags(flags, map[string]string{"template": spec.Template}); err != nil {
+			return err
+		}
+	}
+	messages, err := fillSecrets(spec)
+	if err != nil {
+		return err
+	}
+	var pack *templatePack
+	if spec.Template != "" {
+		if pack, err = loadTemplatePack(spec.Template); err != nil {
+			return err
+		}
+	}
+	plan, err := planInfrastructure(*projectPath, spec, pack)
+	if err != nil {
+		return err
+	}
+	if *app {
+		if err := makeAppFiles(plan, spec, pack); err != nil {
+			return err
+		}
+	}
+	if err := resolveDependencies(*projectPath, plan, spec); err != nil {
+		return err
+	}
+	options := getWriteOptions()
+	options.secrets = secretValues(spec)
+	if err := applyPlan(*projectPath, plan, options); err != nil {
+		return err
+	}
+	printSecretMessages(messages, options.dryRun)
+	return nil
+}
+
+// runDoctor checks an existing project.
+func runDoctor(name string, args []string) error {
+	flags := newFlagSet(name, "-projectPath <path>",
+		"Checks an existing project for missing files, settings and tools.")
+	projectPath := flags.String("projectPath", "", "Path to the project (mandatory)")
+	_ = flags.Parse(args)
+
+	if err := requireFlags(flags, map[string]string{"projectPath": *projectPath}); err != nil {
+		return err
+	}
+	if !diagnoseProject(*projectPath) {
+		return errors.New("the project has problems (see the report above)")
+	}
+	return nil
+}
+
+// printTemplate prints the description, parameters and resources of
+// a template. The detailed version also prints the parameters' details,
+// its files and the extra services.
+func printTemplate(key string, pack *templatePack, detailed bool) {
+	manifest := pack.manifest
+	fmt.Printf("%-20s %s\n", key, manifest.Description)
+	parameters := []string{}
+	for _, parameter := range manifest.Parameters {
+		if parameter.Required {
+			parameters = append(parameters, parameter.Name+" (required)")
+		} else {
+			parameters = append(parameters, parameter.Name+"="+parameter.Default)
+		}
+	}
+	if len(parameters) > 0 {
+		fmt.Printf("%-20s parameters: %s\n", "", strings.Join(parameters, ", "))
+	}
+	if len(manifest.Resources) > 0 {
+		fmt.Printf("%-20s resources: %s\n", "", strings.Join(manifest.Resources, ", "))
+	}
+	if !detailed {
+		return
+	}
+
+	if len(manifest.Parameters) > 0 {
+		fmt.Println("\nParameters:")
+		for _, parameter := range manifest.Parameters {
+			fmt.Printf("  %-18s %s\n", parameter.Name, parameter.Description)
+		}
+	}
+	fmt.Println("\nFiles:")
+	for _, file := range pack.files {
+		fmt.Printf("  %s\n", filepath.ToSlash(file.path))
+	}
+	if services, err := pack.extraServices(nil); err == nil && services != "" {
+		fmt.Println("\nExtra services:" + services)
+	}
+}
+
+// runListTemplates lists the builtin and installed app templates.
+func runListTemplates(name string, args []string) error {
+	flags := newFlagSet(name, "",
+		"Lists the builtin app templates and the ones installed with\n"+
+			"install-template. A path to a file, or to a template pack (a\n"+
+			"directory, .zip, .tar.gz or .tgz with a "+packManifestFileName+"), can\n"+
+			"also be used as template.")
+	_ = flags.Parse(args)
+
+	for _, template := range templates.Builtin {
+		pack, _ := loadTemplatePack(template.Key)
+		printTemplate(template.Key, pack, false)
+	}
+	names, err := installedTemplates()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		key := installedTemplatePrefix + name
+		if pack, err := loadTemplatePack(key); err != nil {
+			fmt.Printf("%-20s (invalid: %s)\n", key, err)
+		} else {
+			printTemplate(key, pack, false)
+		}
+	}
+	return nil
+}
+
+// runDescribeTemplate describes an app template in detail.
+func runDescribeTemplate(name string, args []string) error {
+	flags := newFlagSet(name, "-template <template>",
+		"Describes an app template: its parameters, the resources it defines,\n"+
+			"its files and the services it adds to the stack.")
+	template := flags.String("template", "", "Template to describe (a key, or a path to a file, directory or archive)")
+	_ = flags.Parse(args)
+
+	if err := requireFlags(flags, map[string]string{"template": *template}); err != nil {
+		return err
+	}
+	pack, err := loadTemplatePack(*template)
+	if err != nil {
+		return err
+	}
+	printTemplate(*template, pack, true)
+	return nil
+}
+
+// runInstallTemplate installs a template pack into the template cache.
+func runInstallTemplate(name string, args []string) error {
+	flags := newFlagSet(name, "-source <path> [-name <name>] [-force]",
+		"Installs a template pack (a directory, .zip, .tar.gz or .tgz with a\n"+
+			packManifestFileName+") into the template cache, so it can be used as\n"+
+			"-template local:<name>. The cache is in the user's cache directory,\n"+
+			"unless $"+templateCacheEnv+" tells another one.")
+	source := flags.String("source", "", "Path to the template pack (mandatory)")
+	templateName := flags.String("name", "", "Name to install the template as (default: the one in its manifest)")
+	force := flags.Bool("force", false, "Replace an installed template with the same name")
+	_ = flags.Parse(args)
+
+	if err := requireFlags(flags, map[string]string{"source": *source}); err != nil {
+		return err
+	}
+	key, err := installTemplate(*source, *templateName, *force)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Installed template " + key)
+	return nil
+}
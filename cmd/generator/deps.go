@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Dependency modes: how the dependencies of the server are resolved.
+const (
+	// dependenciesTidy resolves them when building the image, with
+	// whatever is upstream at that moment.
+	dependenciesTidy = "tidy"
+	// dependenciesResolved resolves them on generation, into go.mod
+	// and go.sum, so the image builds with the same versions.
+	dependenciesResolved = "resolved"
+	// dependenciesVendor also copies them into vendor/, so the image
+	// builds without network.
+	dependenciesVendor = "vendor"
+)
+
+// dependencyModes lists the valid dependency modes.
+var dependencyModes = []string{dependenciesTidy, dependenciesResolved, dependenciesVendor}
+
+// runGo runs a go command in a directory, telling its output on failure.
+func runGo(dir string, args ...string) error {
+	command := exec.Command("go", args...)
+	command.Dir = dir
+	command.Env = append(os.Environ(), "GOWORK=off")
+	output := bytes.Buffer{}
+	command.Stdout = &output
+	command.Stderr = &output
+	if err := command.Run(); err != nil {
+		return fmt.Errorf("could not resolve the dependencies: go %s: %w\n%s", strings.Join(args, " "), err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// resolveDependencies resolves the dependencies of the server in the
+// plan, unless they're resolved on build: the server's module is staged
+// in a temporary directory (along with the existing go.sum, so already
+// resolved versions are kept) and tidied, and the resulting go.mod and
+// go.sum (and vendor/, if vendoring) are added to the plan. Files from
+// vendor/ which are not needed anymore are left as they are.
+func resolveDependencies(projectPath string, plan *filePlan, spec *ProjectSpec) error {
+	if spec.Server.Dependencies == dependenciesTidy {
+		return nil
+	}
+
+	sources, err := serverSources(projectPath, plan)
+	if err != nil {
+		return err
+	}
+	goSumPath := filepath.Join("server", "go.sum")
+	if content, err := os.ReadFile(filepath.Join(projectPath, goSumPath)); err == nil {
+		sources[goSumPath] = string(content)
+	} else if !os.IsNotExist(err) {
+		return &PathError{"read", filepath.Join(projectPath, goSumPath), err}
+	}
+
+	staging, err := os.MkdirTemp("", "windrose-server-")
+	if err != nil {
+		return &PathError{"create staging directory", os.TempDir(), err}
+	}
+	defer os.RemoveAll(staging)
+	for name, contents := range sources {
+		filePath := filepath.Join(staging, strings.TrimPrefix(name, "server"+string(filepath.Separator)))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return &PathError{"create directory", filepath.Dir(filePath), err}
+		}
+		if err := dumpFile(filePath, contents, 0644); err != nil {
+			return err
+		}
+	}
+
+	if err := runGo(staging, "mod", "tidy"); err != nil {
+		return err
+	}
+	for _, name := range []string{"go.mod", "go.sum"} {
+		content, err := os.ReadFile(filepath.Join(staging, name))
+		if err != nil {
+			return &PathError{"read resolved", name, err}
+		}
+		plan.add(filepath.Join("server", name), string(content), 0644)
+	}
+	if spec.Server.Dependencies != dependenciesVendor {
+		return nil
+	}
+
+	if err := runGo(staging, "mod", "vendor"); err != nil {
+		return err
+	}
+	return filepath.WalkDir(filepath.Join(staging, "vendor"), func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return &PathError{"read vendored file", filePath, err}
+		}
+		relative, err := filepath.Rel(staging, filePath)
+		if err != nil {
+			return err
+		}
+		plan.add(filepath.Join("server", relative), string(content), 0644)
+		return nil
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines around each hunk.
+const diffContext = 3
+
+// diffLine is a line of a diff: ' ' (kept), '-' (removed) or '+' (added).
+type diffLine struct {
+	kind byte
+	text string
+}
+
+// splitLines splits a text in lines, without the line breaks.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines computes the line-by-line edit script between two texts,
+// using the longest common subsequence of their lines.
+func diffLines(oldLines, newLines []string) []diffLine {
+	// lengths[i][j] is the LCS length of oldLines[i:] and newLines[j:].
+	lengths := make([][]int, len(oldLines)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(newLines)+1)
+	}
+	for i := len(oldLines) - 1; i >= 0; i-- {
+		for j := len(newLines) - 1; j >= 0; j-- {
+			if oldLines[i] == newLines[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+
+	lines := []diffLine{}
+	i, j := 0, 0
+	for i < len(oldLines) && j < len(newLines) {
+		if oldLines[i] == newLines[j] {
+			lines = append(lines, diffLine{' ', oldLines[i]})
+			i++
+			j++
+		} else if lengths[i+1][j] >= lengths[i][j+1] {
+			lines = append(lines, diffLine{'-', oldLines[i]})
+			i++
+		} else {
+			lines = append(lines, diffLine{'+', newLines[j]})
+			j++
+		}
+	}
+	for ; i < len(oldLines); i++ {
+		lines = append(lines, diffLine{'-', oldLines[i]})
+	}
+	for ; j < len(newLines); j++ {
+		lines = append(lines, diffLine{'+', newLines[j]})
+	}
+	return lines
+}
+
+// unifiedDiff renders the unified diff between two texts. It returns
+// an empty string when they are equal.
+func unifiedDiff(oldName, newName, oldText, newText string) string {
+	if oldText == newText {
+		return ""
+	}
+	lines := diffLines(splitLines(oldText), splitLines(newText))
+
+	builder := strings.Builder{}
+	builder.WriteString("--- " + oldName + "\n")
+	builder.WriteString("+++ " + newName + "\n")
+	for start := 0; start < len(lines); {
+		// Find the next change.
+		for start < len(lines) && lines[start].kind == ' ' {
+			start++
+		}
+		if start == len(lines) {
+			break
+		}
+
+		// Extend the hunk while the changes are close enough.
+		end := start
+		for index := start; index < len(lines); index++ {
+			if lines[index].kind != ' ' {
+				end = index + 1
+			} else if index-end >= 2*diffContext {
+				break
+			}
+		}
+		hunkStart := max(start-diffContext, 0)
+		hunkEnd := min(end+diffContext, len(lines))
+
+		// Compute the hunk header, counting the lines of each side.
+		oldStart, newStart := 1, 1
+		for _, line := range lines[:hunkStart] {
+			if line.kind != '+' {
+				oldStart++
+			}
+			if line.kind != '-' {
+				newStart++
+			}
+		}
+		oldCount, newCount := 0, 0
+		for _, line := range lines[hunkStart:hunkEnd] {
+			if line.kind != '+' {
+				oldCount++
+			}
+			if line.kind != '-' {
+				newCount++
+			}
+		}
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+		builder.WriteString(fmt.Sprintf("@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount))
+		for _, line := range lines[hunkStart:hunkEnd] {
+			builder.WriteString(string(line.kind) + line.text + "\n")
+		}
+		start = hunkEnd
+	}
+	return builder.String()
+}
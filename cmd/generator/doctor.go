@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// requiredEnvKeys are the keys the .env file must define.
+var requiredEnvKeys = []string{
+	"MONGO_INITDB_ROOT_USERNAME", "MONGO_INITDB_ROOT_PASSWORD",
+	"DB_HOST", "DB_PORT", "DB_USER", "DB_PASS",
+	"SERVER_API_KEY",
+}
+
+// readEnvFile reads the key/value pairs of an env file.
+func readEnvFile(filePath string) (map[string]string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return values, scanner.Err()
+}
+
+// diagnoseProject checks an existing project and reports each check.
+// It returns whether no problem was found.
+func diagnoseProject(projectPath string) bool {
+	healthy := true
+	report := func(ok bool, message string) {
+		if ok {
+			fmt.Println("[ok]   " + message)
+		} else {
+			fmt.Println("[fail] " + message)
+			healthy = false
+		}
+	}
+
+	// First, the files.
+	for _, file := range []string{
+		"docker-compose.yml", "compose.sh", ".env",
+		filepath.Join("server", "Dockerfile"), filepath.Join("server", "go.mod"), filepath.Join("server", "main.go"),
+	} {
+		_, err := os.Stat(filepath.Join(projectPath, file))
+		report(err == nil, "file "+file+" exists")
+	}
+	if stat, err := os.Stat(filepath.Join(projectPath, "compose.sh")); err == nil {
+		report(stat.Mode()&0111 != 0, "compose.sh is executable")
+	}
+
+	// Then, the manifest.
+	manifestPath := filepath.Join(projectPath, manifestFileName)
+	if _, err := os.Stat(manifestPath); err == nil {
+		err := checkManifest(manifestPath)
+		report(err == nil, manifestFileName+" (and its schema) is valid")
+		if err != nil {
+			fmt.Println("       " + err.Error())
+		}
+	} else {
+		report(false, "file "+manifestFileName+" exists")
+	}
+
+	// Then, the env settings.
+	if values, err := readEnvFile(filepath.Join(projectPath, ".env")); err == nil {
+		for _, key := range requiredEnvKeys {
+			report(values[key] != "", ".env defines "+key)
+		}
+		for _, key := range []string{"MONGO_INITDB_ROOT_PASSWORD", "SERVER_API_KEY"} {
+			report(!slices.Contains(wellKnownSecrets, values[key]), ".env does not use a well-known "+key)
+		}
+	}
+
+	// Finally, the tools.
+	for _, tool := range []string{"docker", "docker-compose"} {
+		_, err := exec.LookPath(tool)
+		report(err == nil, tool+" is installed")
+	}
+
+	return healthy
+}
+
+// checkManifest checks whether a manifest, and its schema if any,
+// can be loaded.
+func checkManifest(manifestPath string) error {
+	spec, err := loadManifest(manifestPath, defaultProjectSpec())
+	if err != nil {
+		return err
+	}
+	if spec.Schema != "" {
+		schema, err := loadSchema(spec.Schema)
+		if err != nil {
+			return err
+		}
+		return schema.normalize()
+	}
+	return nil
+}
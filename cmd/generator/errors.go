@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+)
+
+var (
+	// ErrUsage tells the command was not properly invoked.
+	ErrUsage = errors.New("invalid usage")
+	// ErrTemplateNotFound tells the chosen template does not exist.
+	ErrTemplateNotFound = errors.New("template not found")
+	// ErrPermissionDenied tells a file could not be read or written
+	// due to its permissions. It is the same as fs.ErrPermission, so
+	// it also matches the errors returned by the os package.
+	ErrPermissionDenied = fs.ErrPermission
+	// ErrPathExists tells an existing file would be overwritten.
+	ErrPathExists = errors.New("path exists")
+	// ErrInvalidSpec tells a manifest, schema or template is invalid.
+	ErrInvalidSpec = errors.New("invalid spec")
+)
+
+// Exit codes, so the scripts invoking the generator can react.
+const (
+	exitOK               = 0
+	exitFailure          = 1
+	exitUsage            = 2
+	exitTemplateNotFound = 3
+	exitPermissionDenied = 4
+	exitPathExists       = 5
+	exitInvalidSpec      = 6
+)
+
+// PathError is an error related to a file of the project.
+type PathError struct {
+	// Op is the operation that failed (e.g. "read", "write").
+	Op string
+	// Path is the file the operation failed on.
+	Path string
+	// Err is the underlying error.
+	Err error
+}
+
+// Error returns the error message.
+func (e *PathError) Error() string {
+	return "could not " + e.Op + " " + e.Path + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
+
+// exitCodeFor tells the exit code for the error of a command.
+func exitCodeFor(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, ErrUsage):
+		return exitUsage
+	case errors.Is(err, ErrTemplateNotFound):
+		return exitTemplateNotFound
+	case errors.Is(err, ErrPermissionDenied):
+		return exitPermissionDenied
+	case errors.Is(err, ErrPathExists):
+		return exitPathExists
+	case errors.Is(err, ErrInvalidSpec):
+		return exitInvalidSpec
+	default:
+		return exitFailure
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestExitCodeFor(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"no error", nil, exitOK},
+		{"usage", fmt.Errorf("%w: -projectPath required", ErrUsage), exitUsage},
+		{"template not found", fmt.Errorf("%w: nope", ErrTemplateNotFound), exitTemplateNotFound},
+		{"permission denied", &PathError{"write", "/project/.env", os.ErrPermission}, exitPermissionDenied},
+		{"path exists", fmt.Errorf("%w: 1 existing file(s)", ErrPathExists), exitPathExists},
+		{"invalid spec", schemaError("invalid schema file %s", "schema.yaml"), exitInvalidSpec},
+		{"wrapped twice", fmt.Errorf("init: %w", &PathError{"read", "schema.yaml", fmt.Errorf("%w: bad", ErrInvalidSpec)}), exitInvalidSpec},
+		{"other", &PathError{"read", "schema.yaml", os.ErrNotExist}, exitFailure},
+		{"unknown", errors.New("boom"), exitFailure},
+	}
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if got := exitCodeFor(testCase.err); got != testCase.want {
+				t.Errorf("exitCodeFor(%v) = %d, want %d", testCase.err, got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestPathError(t *testing.T) {
+	err := &PathError{"read schema file", "schema.yaml", os.ErrNotExist}
+	if got, want := err.Error(), "could not read schema file schema.yaml: "+os.ErrNotExist.Error(); got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Error("PathError does not unwrap into its underlying error")
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// chartTemplates holds the templates/ of the Helm chart. They are Helm
+// templates, so they are copied as they are instead of being rendered.
+//
+//go:embed all:chart
+var chartTemplates embed.FS
+
+// helmChartName is the name of the generated Helm chart.
+const helmChartName = "windrose-stack"
+
+var helmChartFileContentsTemplate = strings.TrimSpace(`
+apiVersion: v2
+name: {{ .ChartName }}
+description: A WindRose/NetRose HTTP storage stack (server and MongoDB)
+type: application
+version: 0.1.0
+appVersion: {{ printf "%q" .ImageTag }}
+`)
+
+var helmValuesFileContentsTemplate = strings.TrimSpace(`
+# Default values of the stack, from the project's settings. Override
+# them per environment (e.g. helm install -f production.yaml), instead
+# of regenerating the chart.
+
+image:
+  repository: {{ .ImageRepository }}
+  tag: {{ printf "%q" .ImageTag }}
+  pullPolicy: IfNotPresent
+
+replicaCount: {{ .Kubernetes.Replicas }}
+
+service:
+  type: ClusterIP
+  port: 80
+
+# Enables the non-root user, read-only filesystem and dropped
+# capabilities (as in the production profile).
+hardened: {{ eq .Profile "production" }}
+
+server:
+  # The default API key installed on first setup.
+  apiKey: {{ printf "%q" .Server.APIKey }}
+  # How long the server waits for the in-flight requests on shutdown,
+  # and how long it is given to stop before being killed.
+  shutdownTimeout: {{ printf "%q" .Server.ShutdownTimeout }}
+  terminationGracePeriodSeconds: {{ .Server.GracePeriodSeconds }}
+  # Whether the server runs in debug mode.
+  debug: {{ .ServerDebug }}
+{{- if eq .Profile "production" }}
+  resources:
+    requests:
+      cpu: 100m
+      memory: 128Mi
+    limits:
+      cpu: 500m
+      memory: 256Mi
+{{- else }}
+  resources: {}
+{{- end }}
+
+mongodb:
+  image: mongo:6.0
+  port: 27017
+  user: {{ printf "%q" .Mongo.User }}
+  password: {{ printf "%q" .Mongo.Password }}
+  storageSize: {{ .Kubernetes.StorageSize }}
+  storageClassName: ""
+{{- if eq .Profile "production" }}
+  resources:
+    requests:
+      cpu: 250m
+      memory: 512Mi
+    limits:
+      cpu: "1"
+      memory: 1Gi
+{{- else }}
+  resources: {}
+{{- end }}
+
+ingress:
+  enabled: {{ ne .Kubernetes.IngressHost "" }}
+  className: ""
+  host: {{ printf "%q" .Kubernetes.IngressHost }}
+  annotations: {}
+`)
+
+// helmData is the data to render the Helm chart's files against.
+type helmData struct {
+	*ProjectSpec
+	// ChartName is the name of the chart.
+	ChartName string
+	// ImageRepository is the server image, without the tag.
+	ImageRepository string
+	// ImageTag is the tag of the server image.
+	ImageTag string
+}
+
+// splitImage splits an image reference into its repository and tag
+// (latest, if not given).
+func splitImage(image string) (string, string) {
+	if index := strings.LastIndex(image, ":"); index > strings.LastIndex(image, "/") {
+		return image[:index], image[index+1:]
+	}
+	return image, "latest"
+}
+
+// makeHelmChart makes a Helm chart of the stack in helm/, if enabled.
+// It deploys the same resources as the Kubernetes manifests, but the
+// settings and secrets are values which can be overridden on install.
+func makeHelmChart(plan *filePlan, spec *ProjectSpec) error {
+	if !spec.Kubernetes.Helm {
+		return nil
+	}
+
+	chartPath := filepath.Join("helm", helmChartName)
+	data := helmData{ProjectSpec: spec, ChartName: helmChartName}
+	data.ImageRepository, data.ImageTag = splitImage(spec.Kubernetes.Image)
+	if err := plan.render(filepath.Join(chartPath, "Chart.yaml"), helmChartFileContentsTemplate, data, 0644); err != nil {
+		return err
+	}
+	if err := plan.render(filepath.Join(chartPath, "values.yaml"), helmValuesFileContentsTemplate, data, 0644); err != nil {
+		return err
+	}
+	return fs.WalkDir(chartTemplates, "chart", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		content, err := chartTemplates.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		relative := strings.TrimPrefix(name, "chart/")
+		plan.add(filepath.Join(chartPath, filepath.FromSlash(path.Clean(relative))), string(content), 0644)
+		return nil
+	})
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+var kubernetesKustomizationFileContentsTemplate = strings.TrimSpace(`
+# Apply with: kubectl apply -k .
+# The server image must be built and pushed first, e.g.:
+#   docker build -t {{ .Kubernetes.Image }} ../server
+apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+  - namespace.yaml
+  - secret.yaml
+  - configmap.yaml
+  - mongodb.yaml
+  - http.yaml
+{{- if .Kubernetes.IngressHost }}
+  - ingress.yaml
+{{- end }}
+`)
+
+var kubernetesNamespaceFileContentsTemplate = strings.TrimSpace(`
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: {{ .Kubernetes.Namespace }}
+`)
+
+var kubernetesSecretFileContentsTemplate = strings.TrimSpace(`
+# The same secrets as in the .env file.
+apiVersion: v1
+kind: Secret
+metadata:
+  name: stack-secrets
+  namespace: {{ .Kubernetes.Namespace }}
+type: Opaque
+stringData:
+  MONGO_INITDB_ROOT_USERNAME: {{ printf "%q" .Mongo.User }}
+  MONGO_INITDB_ROOT_PASSWORD: {{ printf "%q" .Mongo.Password }}
+  DB_USER: {{ printf "%q" .Mongo.User }}
+  DB_PASS: {{ printf "%q" .Mongo.Password }}
+  SERVER_API_KEY: {{ printf "%q" .Server.APIKey }}
+`)
+
+var kubernetesConfigMapFileContentsTemplate = strings.TrimSpace(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: stack-config
+  namespace: {{ .Kubernetes.Namespace }}
+data:
+  DB_HOST: mongodb
+  DB_PORT: "27017"
+  SHUTDOWN_TIMEOUT: {{ printf "%q" .Server.ShutdownTimeout }}
+  SERVER_DEBUG: "{{ .ServerDebug }}"
+`)
+
+var kubernetesMongoDBFileContentsTemplate = strings.TrimSpace(`
+apiVersion: v1
+kind: Service
+metadata:
+  name: mongodb
+  namespace: {{ .Kubernetes.Namespace }}
+  labels:
+    app.kubernetes.io/name: mongodb
+spec:
+  clusterIP: None
+  selector:
+    app.kubernetes.io/name: mongodb
+  ports:
+    - name: mongodb
+      port: 27017
+      targetPort: 27017
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: mongodb
+  namespace: {{ .Kubernetes.Namespace }}
+  labels:
+    app.kubernetes.io/name: mongodb
+spec:
+  serviceName: mongodb
+  replicas: 1
+  selector:
+    matchLabels:
+      app.kubernetes.io/name: mongodb
+  template:
+    metadata:
+      labels:
+        app.kubernetes.io/name: mongodb
+    spec:
+      containers:
+        - name: mongodb
+          image: mongo:6.0
+          ports:
+            - name: mongodb
+              containerPort: 27017
+          envFrom:
+            - secretRef:
+                name: stack-secrets
+          readinessProbe:
+            exec:
+              command: ["mongosh", "--quiet", "--eval", "db.adminCommand('ping').ok"]
+            initialDelaySeconds: 10
+            periodSeconds: 10
+            timeoutSeconds: 5
+{{- if eq .Profile "production" }}
+          resources:
+            requests:
+              cpu: 250m
+              memory: 512Mi
+            limits:
+              cpu: "1"
+              memory: 1Gi
+          securityContext:
+            allowPrivilegeEscalation: false
+{{- end }}
+          volumeMounts:
+            - name: data
+              mountPath: /data/db
+  volumeClaimTemplates:
+    - metadata:
+        name: data
+      spec:
+        accessModes: ["ReadWriteOnce"]
+        resources:
+          requests:
+            storage: {{ .Kubernetes.StorageSize }}
+`)
+
+var kubernetesHTTPFileContentsTemplate = strings.TrimSpace(`
+apiVersion: v1
+kind: Service
+metadata:
+  name: http
+  namespace: {{ .Kubernetes.Namespace }}
+  labels:
+    app.kubernetes.io/name: http
+spec:
+  selector:
+    app.kubernetes.io/name: http
+  ports:
+    - name: http
+      port: 80
+      targetPort: 80
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: http
+  namespace: {{ .Kubernetes.Namespace }}
+  labels:
+    app.kubernetes.io/name: http
+spec:
+  replicas: {{ .Kubernetes.Replicas }}
+  selector:
+    matchLabels:
+      app.kubernetes.io/name: http
+  template:
+    metadata:
+      labels:
+        app.kubernetes.io/name: http
+    spec:
+{{- if eq .Profile "production" }}
+      securityContext:
+        runAsNonRoot: true
+        runAsUser: 65534
+        runAsGroup: 65534
+        sysctls:
+          # Lets the non-root user bind port 80.
+          - name: net.ipv4.ip_unprivileged_port_start
+            value: "0"
+{{- end }}
+      # Lets the server drain the in-flight requests on termination.
+      terminationGracePeriodSeconds: {{ .Server.GracePeriodSeconds }}
+      containers:
+        - name: http
+          image: {{ .Kubernetes.Image }}
+          ports:
+            - name: http
+              containerPort: 80
+          envFrom:
+            - configMapRef:
+                name: stack-config
+            - secretRef:
+                name: stack-secrets
+          readinessProbe:
+            httpGet:
+              path: /readyz
+              port: http
+            periodSeconds: 10
+          livenessProbe:
+            httpGet:
+              path: /healthz
+              port: http
+            initialDelaySeconds: 10
+            periodSeconds: 20
+{{- if eq .Profile "production" }}
+          resources:
+            requests:
+              cpu: 100m
+              memory: 128Mi
+            limits:
+              cpu: 500m
+              memory: 256Mi
+          securityContext:
+            readOnlyRootFilesystem: true
+            allowPrivilegeEscalation: false
+            capabilities:
+              drop: ["ALL"]
+{{- end }}
+`)
+
+var kubernetesIngressFileContentsTemplate = strings.TrimSpace(`
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: http
+  namespace: {{ .Kubernetes.Namespace }}
+spec:
+  rules:
+    - host: {{ .Kubernetes.IngressHost }}
+      http:
+        paths:
+          - path: /
+            pathType: Prefix
+            backend:
+              service:
+                name: http
+                port:
+                  name: http
+`)
+
+// makeKubernetesFiles makes the Kubernetes manifests of the stack in
+// k8s/, if enabled. They deploy the same services as the compose file
+// (save for Mongo Express), with the same secrets as the .env file.
+func makeKubernetesFiles(plan *filePlan, spec *ProjectSpec) error {
+	if !spec.Kubernetes.Enabled {
+		return nil
+	}
+
+	files := map[string]string{
+		"kustomization.yaml": kubernetesKustomizationFileContentsTemplate,
+		"namespace.yaml":     kubernetesNamespaceFileContentsTemplate,
+		"secret.yaml":        kubernetesSecretFileContentsTemplate,
+		"configmap.yaml":     kubernetesConfigMapFileContentsTemplate,
+		"mongodb.yaml":       kubernetesMongoDBFileContentsTemplate,
+		"http.yaml":          kubernetesHTTPFileContentsTemplate,
+		"ingress.yaml":       kubernetesIngressFileContentsTemplate,
+	}
+	names := []string{"kustomization.yaml", "namespace.yaml", "secret.yaml", "configmap.yaml", "mongodb.yaml", "http.yaml"}
+	if spec.Kubernetes.IngressHost != "" {
+		names = append(names, "ingress.yaml")
+	}
+	for _, name := range names {
+		if err := plan.render(filepath.Join("k8s", name), files[name], spec, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
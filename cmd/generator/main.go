@@ -1,9 +1,7 @@
 package main
 
 import (
-	"flag"
 	"fmt"
-	"github.com/AlephVault/golang-windrose-http-storage-generator/cmd/generator/templates"
 	"os"
 	"path/filepath"
 	"strings"
@@ -12,36 +10,224 @@ import (
 var dockerComposeFileContentsTemplate = strings.TrimSpace(`
 version: '3.7'
 services:
+{{- if eq .Profile "development" }}
   express:
     image: mongo-express:1.0.0-alpha
     restart: always
     env_file: .env
+    depends_on:
+      mongodb:
+        condition: service_healthy
     ports:
-      - %d:8081
+      - {{ .MongoExpress.Port }}:8081
     expose:
-      - %d
-  mongodb:
+      - {{ .MongoExpress.Port }}
+{{- end }}
+{{- range .MongoMembers }}
+  {{ .Name }}:
     image: mongo:6.0
-    restart: always
+    restart: {{ if eq $.Profile "production" }}unless-stopped{{ else }}always{{ end }}
     env_file: .env
+{{- if not .Primary }}
+    environment:
+      # Only the first member creates the root user. The others get
+      # it when joining the replica set.
+      MONGO_INITDB_ROOT_USERNAME: ""
+      MONGO_INITDB_ROOT_PASSWORD: ""
+{{- end }}
+{{- if $.Mongo.ReplicaSet.Members }}
+    entrypoint:
+      - bash
+      - -c
+      - |
+        echo "$$MONGO_REPLICA_SET_KEY" > /tmp/mongo-keyfile
+        chmod 400 /tmp/mongo-keyfile
+        chown 999:999 /tmp/mongo-keyfile
+        exec docker-entrypoint.sh mongod --replSet {{ $.Mongo.ReplicaSet.Name }} --bind_ip_all --keyFile /tmp/mongo-keyfile
+{{- end }}
+{{- if and .Primary (eq $.Profile "development") }}
     ports:
-      - %d:27017
+      - {{ $.Mongo.Port }}:27017
     expose:
-      - %d
+      - {{ $.Mongo.Port }}
+{{- end }}
+    volumes:
+      - {{ .Volume }}:/data/db
+    healthcheck:
+      test: ["CMD", "mongosh", "--quiet", "--eval", "db.adminCommand('ping').ok"]
+      interval: 10s
+      timeout: 5s
+      retries: 5
+      start_period: 30s
+{{- if eq $.Profile "production" }}
+    read_only: true
+    tmpfs:
+      - /tmp
+    security_opt:
+      - no-new-privileges:true
+    deploy:
+      resources:
+        limits:
+          cpus: "1.0"
+          memory: 1G
+{{- end }}
+{{- end }}
+{{- if .Mongo.ReplicaSet.Members }}
+  mongodb-init:
+    image: mongo:6.0
+    # It retries until the replica set is initiated, and then exits.
+    restart: on-failure
+    env_file: .env
+    depends_on:
+{{- range .MongoMembers }}
+      {{ .Name }}:
+        condition: service_healthy
+{{- end }}
     volumes:
-      - .tmp/mongo:/data/db
+      - ./mongo/init-replica-set.js:/init-replica-set.js:ro
+    entrypoint:
+      - bash
+      - -c
+      - mongosh mongodb://mongodb:27017/admin -u "$$MONGO_INITDB_ROOT_USERNAME" -p "$$MONGO_INITDB_ROOT_PASSWORD" --quiet /init-replica-set.js
+{{- end }}
   http:
     build:
       context: ./server
-    restart: always
+      args:
+        # Taken from the environment, e.g.:
+        #   VERSION=1.0.0 COMMIT=$(git rev-parse HEAD) ./compose.sh build
+        - VERSION
+        - COMMIT
+    restart: {{ if eq .Profile "production" }}unless-stopped{{ else }}always{{ end }}
     env_file: .env
+    # Lets the server drain the in-flight requests on stop.
+    stop_grace_period: {{ .Server.GracePeriodSeconds }}s
+{{- if not .TLS.Enabled }}
     ports:
-      - %d:80
+      - {{ .HTTP.Port }}:80
+{{- if eq .Profile "development" }}
     expose:
-      - %d
+      - {{ .HTTP.Port }}
+{{- end }}
+{{- end }}
+    # The server's setup needs MongoDB, so it waits until it is ready.
+    depends_on:
+      mongodb:
+        condition: service_healthy
+{{- if .Mongo.ReplicaSet.Members }}
+      mongodb-init:
+        condition: service_completed_successfully
+{{- end }}
+    healthcheck:
+      test: ["CMD", "wget", "-q", "-O", "/dev/null", "http://127.0.0.1/readyz"]
+      interval: 10s
+      timeout: 5s
+      retries: 3
+      start_period: 10s
+{{- if eq .Profile "production" }}
+    user: "65534:65534"
+    read_only: true
+    tmpfs:
+      - /tmp
+    cap_drop:
+      - ALL
+    security_opt:
+      - no-new-privileges:true
+    sysctls:
+      # Lets the non-root user bind port 80.
+      net.ipv4.ip_unprivileged_port_start: 0
+    deploy:
+      resources:
+        limits:
+          cpus: "0.5"
+          memory: 256M
+{{- end }}
+{{- if .TLS.Enabled }}
+  proxy:
+    image: caddy:2.7
+    restart: {{ if eq .Profile "production" }}unless-stopped{{ else }}always{{ end }}
+    ports:
+      - {{ .TLS.Port }}:443
+    depends_on:
+      http:
+        condition: service_healthy
+    volumes:
+      - ./proxy/Caddyfile:/etc/caddy/Caddyfile:ro
+{{- if .TLS.CertFile }}
+      - {{ .TLS.CertVolume }}:/certs/tls.crt:ro
+      - {{ .TLS.KeyVolume }}:/certs/tls.key:ro
+{{- end }}
+      - .tmp/caddy:/data
+{{- if eq .Profile "production" }}
+    read_only: true
+    tmpfs:
+      - /config
+    cap_drop:
+      - ALL
+    cap_add:
+      - NET_BIND_SERVICE
+    security_opt:
+      - no-new-privileges:true
+    deploy:
+      resources:
+        limits:
+          cpus: "0.5"
+          memory: 128M
+{{- end }}
+{{- end }}
+{{- if .Metrics.Services }}
+  prometheus:
+    image: prom/prometheus:v2.53.0
+    restart: {{ if eq .Profile "production" }}unless-stopped{{ else }}always{{ end }}
+    depends_on:
+      - http
+{{- if eq .Profile "development" }}
+    ports:
+      - {{ .Metrics.PrometheusPort }}:9090
+{{- end }}
+    volumes:
+      - ./monitoring/prometheus.yml:/etc/prometheus/prometheus.yml:ro
+      - prometheus-data:/prometheus
+  grafana:
+    image: grafana/grafana:11.1.0
+    restart: {{ if eq .Profile "production" }}unless-stopped{{ else }}always{{ end }}
+    env_file: .env
+    depends_on:
+      - prometheus
+    ports:
+      - {{ if eq .Profile "production" }}127.0.0.1:{{ end }}{{ .Metrics.GrafanaPort }}:3000
+    volumes:
+      - ./monitoring/grafana/provisioning:/etc/grafana/provisioning:ro
+      - ./monitoring/grafana/dashboards:/var/lib/grafana/dashboards:ro
+      - grafana-data:/var/lib/grafana
+{{- end }}
+{{- .ExtraServices }}
+{{- if .Metrics.Services }}
+volumes:
+  prometheus-data:
+  grafana-data:
+{{- end }}
+`)
+
+var mongoReplicaSetInitFileContentsTemplate = strings.TrimSpace(`
+// Initiates the replica set, unless it is already initiated.
+try {
+  rs.status();
+  print("The replica set is already initiated");
+} catch (e) {
+  rs.initiate({
+    _id: {{ printf "%q" .Mongo.ReplicaSet.Name }},
+    members: [
+{{- range $index, $member := .MongoMembers }}
+      {_id: {{ $index }}, host: "{{ $member.Name }}:27017"},
+{{- end }}
+    ],
+  });
+  print("The replica set was initiated");
+}
 `)
 
-var dockerComposeLauncherFileContents = strings.TrimSpace(`
+var dockerComposeLauncherFileContentsTemplate = strings.TrimSpace(`
 #!/bin/bash
 DIR="$(dirname "$0")"
 (cd "$DIR" && docker-compose $@)
@@ -49,152 +235,247 @@ DIR="$(dirname "$0")"
 
 var envFileContentsTemplate = strings.TrimSpace(`
 # These environment variables stand for all the containers
-MONGO_INITDB_ROOT_USERNAME=%s
-MONGO_INITDB_ROOT_PASSWORD=%s
+MONGO_INITDB_ROOT_USERNAME={{ .Mongo.User }}
+MONGO_INITDB_ROOT_PASSWORD={{ .Mongo.Password }}
 DB_HOST=mongodb
 DB_PORT=27017
-DB_USER=%s
-DB_PASS=%s
+DB_USER={{ .Mongo.User }}
+DB_PASS={{ .Mongo.Password }}
+{{- if eq .Profile "development" }}
 ME_CONFIG_MONGODB_SERVER=mongodb
 ME_CONFIG_MONGODB_PORT=27017
-ME_CONFIG_MONGODB_ADMINUSERNAME=%s
-ME_CONFIG_MONGODB_ADMINPASSWORD=%s
-SERVER_API_KEY=%s
+ME_CONFIG_MONGODB_ADMINUSERNAME={{ .Mongo.User }}
+ME_CONFIG_MONGODB_ADMINPASSWORD={{ .Mongo.Password }}
+{{- end }}
+{{- if .Mongo.ReplicaSet.Members }}
+# The server connects to the first member, and discovers the others from it.
+MONGO_REPLICA_SET_KEY={{ .Mongo.ReplicaSet.Key }}
+{{- end }}
+{{- if .Metrics.Services }}
+GF_SECURITY_ADMIN_USER=admin
+GF_SECURITY_ADMIN_PASSWORD={{ .Metrics.GrafanaPassword }}
+{{- end }}
+LOG_LEVEL={{ if eq .Profile "development" }}debug{{ else }}info{{ end }}
+SHUTDOWN_TIMEOUT={{ .Server.ShutdownTimeout }}
+SERVER_DEBUG={{ .ServerDebug }}
+SERVER_API_KEY={{ .Server.APIKey }}
 `)
 
-var moduleFileContents = strings.TrimSpace(`
-module my-project
+var moduleFileContentsTemplate = strings.TrimSpace(`
+module {{ .Server.Module }}
 
-// You might want to change the golang version.
-go 1.22
+go {{ .Server.GoVersion }}
 
-require github.com/AlephVault/golang-standard-http-mongodb-storage v1.3.2
+{{ if .Metrics.Enabled -}}
+require (
+	github.com/AlephVault/golang-standard-http-mongodb-storage {{ .Server.StorageVersion }}
+	github.com/prometheus/client_golang v1.19.1
+)
+{{- else -}}
+require github.com/AlephVault/golang-standard-http-mongodb-storage {{ .Server.StorageVersion }}
+{{- end }}
 `)
 
-var dockerFileContents = strings.TrimSpace(`
-FROM golang:1.22 AS builder
+var dockerFileContentsTemplate = strings.TrimSpace(`
+FROM golang:{{ .Server.GoVersion }} AS builder
+# The build info served in /version.
+ARG VERSION=dev
+ARG COMMIT=unknown
 WORKDIR /app
 COPY ./ /app
+{{- if eq .Server.Dependencies "vendor" }}
+RUN CGO_ENABLED=0 GOOS=linux go build -mod=vendor -a -installsuffix cgo \
+    -ldflags "-X main.version=${VERSION} -X main.commit=${COMMIT} -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)" -o myapp .
+{{- else if eq .Server.Dependencies "resolved" }}
+RUN go mod download
+RUN CGO_ENABLED=0 GOOS=linux go build -mod=readonly -a -installsuffix cgo \
+    -ldflags "-X main.version=${VERSION} -X main.commit=${COMMIT} -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)" -o myapp .
+{{- else }}
 RUN GOPROXY=direct go mod tidy
-RUN CGO_ENABLED=0 GOOS=linux go build -a -installsuffix cgo -o myapp ./main.go
+RUN CGO_ENABLED=0 GOOS=linux go build -a -installsuffix cgo \
+    -ldflags "-X main.version=${VERSION} -X main.commit=${COMMIT} -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)" -o myapp .
+{{- end }}
 
 FROM alpine:latest  
 RUN apk --no-cache add ca-certificates
+{{- if eq .Profile "production" }}
+WORKDIR /app
+COPY --from=builder /app/myapp .
+USER 65534:65534
+{{- else }}
 WORKDIR /root/
 COPY --from=builder /app/myapp .
+{{- end }}
 CMD ["./myapp"]
 `)
 
 // dumpFile dumps a file's contents.
-func dumpFile(filePath, content string, perm os.FileMode) {
+func dumpFile(filePath, content string, perm os.FileMode) error {
 	if err := os.WriteFile(filePath, []byte(content), perm); err != nil {
-		panic("could not dump file " + filePath + ": " + err.Error())
+		return &PathError{"write", filePath, err}
+	}
+	return nil
+}
+
+// composeServices are the services of the docker-compose file, which
+// template packs cannot redefine.
+var composeServices = []string{"express", "mongodb", "mongodb-2", "mongodb-3", "mongodb-init", "http", "proxy", "prometheus", "grafana"}
+
+// mongoMember is a MongoDB service of the docker-compose file.
+type mongoMember struct {
+	// Name is the name of the service.
+	Name string
+	// Volume is the host directory of the data.
+	Volume string
+	// Primary tells whether it is the first member (the one which is
+	// published, and creates the root user).
+	Primary bool
+}
+
+// composeData is the data to render the docker-compose file against.
+type composeData struct {
+	*ProjectSpec
+	// MongoMembers are the MongoDB services: a standalone one, or the
+	// members of the replica set.
+	MongoMembers []mongoMember
+	// ExtraServices are the (already indented) services added by the
+	// template pack.
+	ExtraServices string
+}
+
+// mongoMembers tells the MongoDB services of the spec.
+func mongoMembers(spec *ProjectSpec) []mongoMember {
+	members := []mongoMember{{"mongodb", ".tmp/mongo", true}}
+	for index := 2; index <= spec.Mongo.ReplicaSet.Members; index++ {
+		members = append(members, mongoMember{fmt.Sprintf("mongodb-%d", index), fmt.Sprintf(".tmp/mongo-%d", index), false})
 	}
+	return members
 }
 
-// makeDockerComposeFile makes and dumps the contents of the compose file.
-func makeDockerComposeFile(projectPath string, mongoPort uint16, httpPort uint16, mongoExpressPort uint16) {
+// makeDockerComposeFile makes the contents of the compose file.
+func makeDockerComposeFile(plan *filePlan, spec *ProjectSpec, pack *templatePack) error {
 	// Suggested ports: mongo=27017, http=8080, express=8081.
-	dumpFile(filepath.Join(projectPath, "docker-compose.yml"), fmt.Sprintf(
-		dockerComposeFileContentsTemplate,
-		mongoExpressPort, mongoExpressPort,
-		mongoPort, mongoPort,
-		httpPort, httpPort,
-	), 0644)
+	data := composeData{ProjectSpec: spec, MongoMembers: mongoMembers(spec)}
+	if pack != nil {
+		services, err := pack.extraServices(composeServices)
+		if err != nil {
+			return err
+		}
+		data.ExtraServices = services
+	}
+	return plan.render("docker-compose.yml", dockerComposeFileContentsTemplate, data, 0644)
 }
 
-// makeDockerComposeLauncherFile makes and dumps the contents of the script that launches the compose file.
-func makeDockerComposeLauncherFile(projectPath string) {
-	dumpFile(filepath.Join(projectPath, "compose.sh"), dockerComposeLauncherFileContents, 0755)
+// makeMongoReplicaSetInitFile makes the script that initiates the
+// replica set, if any.
+func makeMongoReplicaSetInitFile(plan *filePlan, spec *ProjectSpec) error {
+	if spec.Mongo.ReplicaSet.Members == 0 {
+		return nil
+	}
+	data := composeData{ProjectSpec: spec, MongoMembers: mongoMembers(spec)}
+	return plan.render(filepath.Join("mongo", "init-replica-set.js"), mongoReplicaSetInitFileContentsTemplate, data, 0644)
+}
+
+// makeDockerComposeLauncherFile makes the contents of the script that launches the compose file.
+func makeDockerComposeLauncherFile(plan *filePlan, spec *ProjectSpec) error {
+	return plan.render("compose.sh", dockerComposeLauncherFileContentsTemplate, spec, 0755)
 }
 
 // makeEnvFile makes the suitable env file.
-func makeEnvFile(projectPath, mongoUser, mongoPass, serverAPIKey string) {
-	dumpFile(filepath.Join(projectPath, ".env"), fmt.Sprintf(
-		envFileContentsTemplate,
-		mongoUser, mongoPass,
-		mongoUser, mongoPass,
-		mongoUser, mongoPass,
-		serverAPIKey,
-	), 0644)
+func makeEnvFile(plan *filePlan, spec *ProjectSpec) error {
+	return plan.render(".env", envFileContentsTemplate, spec, 0644)
 }
 
-// makeModuleFile creates the go.mod file.
-func makeModuleFile(projectPath string) {
-	dumpFile(filepath.Join(projectPath, "server", "go.mod"), moduleFileContents, 0644)
+// makeModuleFile makes the go.mod file.
+func makeModuleFile(plan *filePlan, spec *ProjectSpec) error {
+	return plan.render(filepath.Join("server", "go.mod"), moduleFileContentsTemplate, spec, 0644)
 }
 
-// makeDockerFile creates the proper dockerfile contents.
-func makeDockerFile(projectPath string) {
-	dumpFile(filepath.Join(projectPath, "server", "Dockerfile"), dockerFileContents, 0644)
+// makeDockerFile makes the proper dockerfile contents.
+func makeDockerFile(plan *filePlan, spec *ProjectSpec) error {
+	return plan.render(filepath.Join("server", "Dockerfile"), dockerFileContentsTemplate, spec, 0644)
 }
 
-// makeAppFile creates the contents of the app file depending on the chosen template.
-func makeAppFile(projectPath, template string) {
-	contents := ""
-	if template == "default:simple" {
-		contents = templates.SimpleAppTemplate
-	} else if template == "default:multichar" {
-		contents = templates.MultipleAppTemplates
-	} else {
-		if content, err := os.ReadFile(template); err == nil {
-			contents = string(content)
-		} else {
-			panic("could not read template file " + template + ": " + err.Error())
+// makeAppFiles makes the files of the app template pack, which can
+// not collide with the stack files. They are rendered with the values
+// of the template's parameters.
+func makeAppFiles(plan *filePlan, spec *ProjectSpec, pack *templatePack) error {
+	parameters, err := pack.parameterValues(spec)
+	if err != nil {
+		return err
+	}
+	data := *spec
+	data.Parameters = parameters
+	for _, file := range pack.files {
+		if plan.has(file.path) {
+			return fmt.Errorf("%w: template %s would overwrite the generated %s", ErrInvalidSpec, pack.manifest.Name, filepath.ToSlash(file.path))
+		}
+		if !file.render {
+			plan.add(file.path, file.contents, file.mode)
+		} else if err := plan.render(file.path, file.contents, &data, file.mode); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	dumpFile(filepath.Join(projectPath, "server", "main.go"), contents, 0644)
+// planInfrastructure plans all the files of the stack, save for the
+// app files. This is the part that can be regenerated safely. The
+// template pack, if any, might add services to the stack.
+func planInfrastructure(projectPath string, spec *ProjectSpec, pack *templatePack) (*filePlan, error) {
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	plan := &filePlan{}
+	if err := makeDockerComposeFile(plan, spec, pack); err != nil {
+		return nil, err
+	}
+	for _, makeFile := range []func(*filePlan, *ProjectSpec) error{
+		makeDockerComposeLauncherFile,
+		makeMongoReplicaSetInitFile,
+		makeProxyFile,
+		makeEnvFile,
+		makeDockerFile,
+		makeModuleFile,
+		makeServeFiles,
+		makeMetricsFiles,
+		makeResourcesFile,
+		makeKubernetesFiles,
+		makeHelmChart,
+	} {
+		if err := makeFile(plan, spec); err != nil {
+			return nil, err
+		}
+	}
+	if err := makeManifestFile(plan, projectPath, spec); err != nil {
+		return nil, err
+	}
+	return plan, nil
 }
 
-// generateProject generates an entire project stack.
-// This one will be only suitable for development.
-func generateProject(
-	projectPath, template string,
-	mongoPort, httpPort, mongoExpressPort uint16,
-	mongoUser, mongoPass, serverAPIKey string,
-) {
-	if err := os.MkdirAll(filepath.Join(projectPath, "server"), 0755); err != nil {
-		panic("could not create project directory " + projectPath + ": " + err.Error())
+// generateProject generates an entire project stack, suitable for
+// development or production depending on the spec's profile.
+func generateProject(projectPath string, spec *ProjectSpec, options writeOptions) error {
+	pack, err := loadTemplatePack(spec.Template)
+	if err != nil {
+		return err
 	}
-	makeDockerComposeFile(projectPath, mongoPort, httpPort, mongoExpressPort)
-	makeDockerComposeLauncherFile(projectPath)
-	makeEnvFile(projectPath, mongoUser, mongoPass, serverAPIKey)
-	makeDockerFile(projectPath)
-	makeModuleFile(projectPath)
-	makeAppFile(projectPath, template)
+	plan, err := planInfrastructure(projectPath, spec, pack)
+	if err != nil {
+		return err
+	}
+	if err := makeAppFiles(plan, spec, pack); err != nil {
+		return err
+	}
+	if err := resolveDependencies(projectPath, plan, spec); err != nil {
+		return err
+	}
+	return applyPlan(projectPath, plan, options)
 }
 
 func main() {
-	defer func() {
-		if v := recover(); v != nil {
-			_, _ = fmt.Fprintln(os.Stderr, "Error on generation:", v)
-		}
-	}()
-
-	// Define flags
-	projectPath := flag.String("projectPath", "", "Path to the project (mandatory)")
-	template := flag.String("template", "", "Template to use (\"default:simple\", \"default:multichar\" or a path to a file)")
-	mongoDBPort := flag.Uint("mongoDBPort", 27017, "MongoDB port to use")
-	httpPort := flag.Uint("httpPort", 8080, "HTTP port to use")
-	mongoDBExpressPort := flag.Uint("mongoDBExpressPort", 8081, "MongoDB Express port to use")
-	mongoDBUser := flag.String("mongoDBUser", "admin", "MongoDB user")
-	mongoDBPassword := flag.String("mongoDBPassword", "p455w0rd", "MongoDB password")
-	defaultAPIKey := flag.String("defaultAPIKey", "sample-abcdef", "Default server API key")
-
-	// Parse the flags
-	flag.Parse()
-
-	// Check for mandatory string arguments
-	if *projectPath == "" || *template == "" {
-		_, _ = fmt.Fprintln(os.Stderr, "projectPath and template are required.")
-		flag.PrintDefaults()
-		os.Exit(1)
+	if err := runCommand(os.Args[1:]); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCodeFor(err))
 	}
-
-	generateProject(
-		*projectPath, *template,
-		uint16(*mongoDBPort), uint16(*httpPort), uint16(*mongoDBExpressPort),
-		*mongoDBUser, *mongoDBPassword, *defaultAPIKey,
-	)
 }
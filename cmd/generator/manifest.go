@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"github.com/AlephVault/golang-windrose-http-storage-generator/cmd/generator/templates"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// manifestFileName is the name of the manifest in the project root.
+const manifestFileName = "windrose.yaml"
+
+// manifestVersion is the current version of the manifest format.
+const manifestVersion = 1
+
+// manifest is the declarative description of a project. Since YAML
+// is a superset of JSON, manifests can also be written in JSON.
+type manifest struct {
+	// Version is the version of the manifest format.
+	Version int `yaml:"version"`
+	// Project is the spec of the project.
+	Project ProjectSpec `yaml:",inline"`
+}
+
+// isTemplateKey tells whether the template is a builtin or installed
+// one, as opposed to a path to a file, directory or archive.
+func isTemplateKey(template string) bool {
+	_, ok := templates.FindBuiltin(template)
+	return ok || strings.HasPrefix(template, installedTemplatePrefix)
+}
+
+// loadManifest loads a project spec from a manifest file. The given
+// base spec provides the values the manifest does not mention. The
+// template, if a relative path to a file, is relative to the manifest.
+func loadManifest(manifestPath string, base *ProjectSpec) (*ProjectSpec, error) {
+	content, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, &PathError{"read manifest file", manifestPath, err}
+	}
+
+	data := manifest{Project: *base}
+	if err := yaml.Unmarshal(content, &data); err != nil {
+		return nil, fmt.Errorf("%w: could not parse manifest file %s: %w", ErrInvalidSpec, manifestPath, err)
+	}
+	if data.Version > manifestVersion {
+		return nil, fmt.Errorf("%w: unsupported manifest version in %s: %d", ErrInvalidSpec, manifestPath, data.Version)
+	}
+
+	spec := &data.Project
+	if spec.Template != "" && !isTemplateKey(spec.Template) && !filepath.IsAbs(spec.Template) {
+		spec.Template = filepath.Join(filepath.Dir(manifestPath), spec.Template)
+	}
+	if spec.Schema != "" && !filepath.IsAbs(spec.Schema) {
+		spec.Schema = filepath.Join(filepath.Dir(manifestPath), spec.Schema)
+	}
+	return spec, nil
+}
+
+// loadEnvSecrets loads the secrets of a project spec from the .env
+// file of an existing project, if it exists.
+func loadEnvSecrets(projectPath string, spec *ProjectSpec) error {
+	values, err := readEnvFile(filepath.Join(projectPath, ".env"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return &PathError{"read env file", filepath.Join(projectPath, ".env"), err}
+	}
+
+	if value := values["MONGO_INITDB_ROOT_PASSWORD"]; value != "" {
+		spec.Mongo.Password = value
+	}
+	if value := values["SERVER_API_KEY"]; value != "" {
+		spec.Server.APIKey = value
+	}
+	if value := values["GF_SECURITY_ADMIN_PASSWORD"]; value != "" {
+		spec.Metrics.GrafanaPassword = value
+	}
+	if value := values["MONGO_REPLICA_SET_KEY"]; value != "" {
+		spec.Mongo.ReplicaSet.Key = value
+	}
+	return nil
+}
+
+// marshalYAML serializes a value (or node) as YAML, with 2-space indentation.
+func marshalYAML(value any) (string, error) {
+	builder := strings.Builder{}
+	encoder := yaml.NewEncoder(&builder)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(value); err != nil {
+		return "", fmt.Errorf("could not serialize to YAML: %w", err)
+	}
+	_ = encoder.Close()
+	return builder.String(), nil
+}
+
+// relativeToProject makes a path relative to the project, if possible.
+func relativeToProject(projectPath, path string) string {
+	if absProject, err := filepath.Abs(projectPath); err == nil {
+		if absPath, err := filepath.Abs(path); err == nil {
+			if relative, err := filepath.Rel(absProject, absPath); err == nil {
+				return relative
+			}
+		}
+	}
+	return path
+}
+
+// makeManifestFile makes the project's manifest from the project spec.
+// Paths to files (template or schema) are stored relative to the project.
+func makeManifestFile(plan *filePlan, projectPath string, spec *ProjectSpec) error {
+	stored := *spec
+	if stored.Template != "" && !isTemplateKey(stored.Template) {
+		stored.Template = relativeToProject(projectPath, stored.Template)
+	}
+	if stored.Schema != "" {
+		stored.Schema = relativeToProject(projectPath, stored.Schema)
+	}
+
+	content, err := marshalYAML(&manifest{Version: manifestVersion, Project: stored})
+	if err != nil {
+		return err
+	}
+	plan.add(manifestFileName, content, 0644)
+	return nil
+}
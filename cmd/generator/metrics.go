@@ -0,0 +1,125 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// monitoringFiles holds the configuration of the monitoring services
+// (Prometheus and Grafana, with the dashboard), copied as they are.
+//
+//go:embed monitoring
+var monitoringFiles embed.FS
+
+var metricsFileContentsTemplate = strings.TrimSpace(`
+// Code generated by the windrose generator. DO NOT EDIT.
+
+package main
+
+import (
+	"context"
+	"github.com/AlephVault/golang-standard-http-mongodb-storage/core/dsl"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// metricsAddress is the internal address the metrics are served on. It
+// is not published, so only the services of the stack reach it.
+const metricsAddress = "0.0.0.0:9100"
+
+var (
+	// requestsTotal counts the requests to the app.
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "windrose_http_requests_total",
+		Help: "Requests to the app, per resource, HTTP method and status code.",
+	}, []string{"resource", "method", "code"})
+	// requestDuration measures the latency of the requests to the app.
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "windrose_http_request_duration_seconds",
+		Help:    "Latency of the requests to the app, per resource and HTTP method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"resource", "method"})
+	// authFailuresTotal counts the requests rejected for their API key.
+	authFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "windrose_auth_failures_total",
+		Help: "Requests rejected for a missing, invalid or unauthorized API key, per resource.",
+	}, []string{"resource"})
+	// mongoPingDuration measures the round trips of the MongoDB pings.
+	mongoPingDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "windrose_mongodb_ping_duration_seconds",
+		Help:    "Round trip of the periodic pings to MongoDB (not of the operations of the app).",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	})
+)
+
+func init() {
+	prometheus.MustRegister(requestsTotal, requestDuration, authFailuresTotal, mongoPingDuration)
+}
+
+// instrument measures the requests to the app.
+func instrument(next http.Handler, resources map[string]dsl.Resource) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		resource := resourceLabel(resources, r.URL.Path)
+		requestsTotal.WithLabelValues(resource, r.Method, strconv.Itoa(recorder.status)).Inc()
+		requestDuration.WithLabelValues(resource, r.Method).Observe(time.Since(start).Seconds())
+		if recorder.status == http.StatusUnauthorized || recorder.status == http.StatusForbidden {
+			authFailuresTotal.WithLabelValues(resource).Inc()
+		}
+	})
+}
+
+// watchMongo pings MongoDB periodically, measuring the round trips.
+// The storage library makes the client, so its commands cannot be
+// monitored: the pings tell the latency instead.
+func watchMongo(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		client := mongoClient.Load()
+		if client == nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		start := time.Now()
+		if err := client.Ping(ctx, readpref.Primary()); err == nil {
+			mongoPingDuration.Observe(time.Since(start).Seconds())
+		}
+		cancel()
+	}
+}
+`) + "\n"
+
+// makeMetricsFiles makes the metrics file of the server and, if the
+// monitoring services are added, their configuration (in monitoring/).
+func makeMetricsFiles(plan *filePlan, spec *ProjectSpec) error {
+	if !spec.Metrics.Enabled {
+		return nil
+	}
+	if err := plan.render(filepath.Join("server", "metrics.go"), metricsFileContentsTemplate, spec, 0644); err != nil {
+		return err
+	}
+	if !spec.Metrics.Services {
+		return nil
+	}
+	return fs.WalkDir(monitoringFiles, "monitoring", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		content, err := monitoringFiles.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		plan.add(filepath.FromSlash(path.Clean(name)), string(content), 0644)
+		return nil
+	})
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"github.com/AlephVault/golang-windrose-http-storage-generator/cmd/generator/templates"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// packManifestFileName is the name of the manifest in the root of a
+// template pack.
+const packManifestFileName = "template.yaml"
+
+// packTemplateSuffix is the suffix of the files of a template pack
+// which are rendered (and stored without the suffix). The other files
+// are copied as they are.
+const packTemplateSuffix = ".tmpl"
+
+// packManifest describes a template pack.
+type packManifest struct {
+	// Name is the name of the template.
+	Name string `yaml:"name"`
+	// Description is a short human-readable description.
+	Description string `yaml:"description"`
+	// Parameters are the parameters the template takes. They are
+	// available to the rendered files as {{ .Parameters.name }}.
+	Parameters []packParameter `yaml:"parameters"`
+	// Resources are the names of the resources the template defines.
+	Resources []string `yaml:"resources"`
+	// Services are extra docker-compose services, as in the services
+	// section of a docker-compose file.
+	Services yaml.Node `yaml:"services"`
+}
+
+// packParameter describes a parameter of a template pack.
+type packParameter struct {
+	// Name is the name of the parameter.
+	Name string `yaml:"name"`
+	// Description is a short human-readable description.
+	Description string `yaml:"description"`
+	// Default is the value to use when the parameter is not given.
+	Default string `yaml:"default"`
+	// Required tells the parameter must be given.
+	Required bool `yaml:"required"`
+}
+
+// packFile is a file of a template pack, relative to the project.
+type packFile struct {
+	path     string
+	contents string
+	mode     os.FileMode
+	// render tells whether the contents are a template to render.
+	render bool
+}
+
+// templatePack is an app template: a set of files to add to the
+// project (at least server/main.go) and, optionally, extra services
+// for the docker-compose file. Single-file templates are packs with
+// just server/main.go.
+type templatePack struct {
+	manifest packManifest
+	files    []packFile
+	// sources are the files of the pack as they are, including the
+	// manifest, relative to the pack's root.
+	sources map[string]packFile
+}
+
+// isArchive tells whether a path is a template pack archive.
+func isArchive(location string) bool {
+	return strings.HasSuffix(location, ".zip") || strings.HasSuffix(location, ".tar.gz") ||
+		strings.HasSuffix(location, ".tgz")
+}
+
+// singleFilePack makes a pack out of a single app template. Its file
+// is plain Go, copied as it is: only the pack files ending in .tmpl
+// are rendered.
+func singleFilePack(name, description string, resources []string, contents string) *templatePack {
+	return &templatePack{
+		manifest: packManifest{Name: name, Description: description, Resources: resources},
+		files:    []packFile{{filepath.Join("server", "main.go"), contents, 0644, false}},
+	}
+}
+
+// loadTemplatePack loads an app template: a builtin one, an installed
+// one, a single file, a directory or an archive (.zip, .tar.gz or .tgz)
+// with a template.yaml manifest.
+func loadTemplatePack(location string) (*templatePack, error) {
+	if template, ok := templates.FindBuiltin(location); ok {
+		return singleFilePack(template.Key, template.Description, template.Resources, template.Contents), nil
+	}
+	if name, ok := strings.CutPrefix(location, installedTemplatePrefix); ok {
+		return loadInstalledTemplate(name)
+	}
+
+	stat, err := os.Stat(location)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s is neither a builtin template nor an existing file or directory", ErrTemplateNotFound, location)
+	} else if err != nil {
+		return nil, &PathError{"access template", location, err}
+	}
+
+	if !stat.IsDir() && !isArchive(location) {
+		content, err := os.ReadFile(location)
+		if err != nil {
+			return nil, &PathError{"read template file", location, err}
+		}
+		return singleFilePack(filepath.Base(location), "", nil, string(content)), nil
+	}
+	entries, err := readPackEntries(location, stat.IsDir())
+	if err != nil {
+		return nil, err
+	}
+	return newTemplatePack(location, entries)
+}
+
+// readPackEntries reads all the regular files of a template pack
+// directory or archive.
+func readPackEntries(location string, isDir bool) (map[string]packFile, error) {
+	var entries map[string]packFile
+	var err error
+	switch {
+	case isDir:
+		entries, err = readPackFS(os.DirFS(location))
+	case strings.HasSuffix(location, ".zip"):
+		entries, err = readZipPack(location)
+	default:
+		entries, err = readTarPack(location)
+	}
+	if err != nil {
+		return nil, &PathError{"read template pack", location, err}
+	}
+	return entries, nil
+}
+
+// readPackFS reads all the regular files of a template pack.
+func readPackFS(fsys fs.FS) (map[string]packFile, error) {
+	entries := map[string]packFile{}
+	err := fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		entries[name] = packFile{path: name, contents: string(content), mode: info.Mode().Perm()}
+		return nil
+	})
+	return entries, err
+}
+
+// readZipPack reads all the regular files of a zipped template pack.
+func readZipPack(location string) (map[string]packFile, error) {
+	reader, err := zip.OpenReader(location)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return readPackFS(reader)
+}
+
+// readTarPack reads all the regular files of a gzipped tarball with a
+// template pack.
+func readTarPack(location string) (map[string]packFile, error) {
+	file, err := os.Open(location)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	compressed, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]packFile{}
+	reader := tar.NewReader(compressed)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+		entries[name] = packFile{path: name, contents: string(content), mode: header.FileInfo().Mode().Perm()}
+	}
+}
+
+// newTemplatePack makes a template pack out of its files. The manifest
+// can be in the root or in a single top-level directory (as archives
+// often have).
+func newTemplatePack(location string, entries map[string]packFile) (*templatePack, error) {
+	root := ""
+	if _, ok := entries[packManifestFileName]; !ok {
+		for name := range entries {
+			if path.Base(name) == packManifestFileName && strings.Count(name, "/") == 1 {
+				root = path.Dir(name) + "/"
+				break
+			}
+		}
+		if root == "" {
+			return nil, fmt.Errorf("%w: template pack %s has no %s", ErrInvalidSpec, location, packManifestFileName)
+		}
+	}
+
+	pack := &templatePack{sources: map[string]packFile{}}
+	if err := yaml.Unmarshal([]byte(entries[root+packManifestFileName].contents), &pack.manifest); err != nil {
+		return nil, fmt.Errorf("%w: could not parse %s of template pack %s: %w", ErrInvalidSpec, packManifestFileName, location, err)
+	}
+	if pack.manifest.Name == "" {
+		pack.manifest.Name = strings.TrimSuffix(filepath.Base(location), filepath.Ext(location))
+	}
+	if services := pack.manifest.Services; services.Kind != 0 && services.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%w: the services of template pack %s must be a mapping", ErrInvalidSpec, location)
+	}
+
+	for _, parameter := range pack.manifest.Parameters {
+		if parameter.Name == "" {
+			return nil, fmt.Errorf("%w: template pack %s has a parameter without name", ErrInvalidSpec, location)
+		}
+	}
+
+	for name, entry := range entries {
+		if !strings.HasPrefix(name, root) {
+			continue
+		}
+		name = strings.TrimPrefix(name, root)
+		if !filepath.IsLocal(name) {
+			return nil, fmt.Errorf("%w: template pack %s has a file outside the project: %s", ErrInvalidSpec, location, name)
+		}
+		entry.path = name
+		pack.sources[name] = entry
+		if name == packManifestFileName {
+			continue
+		}
+		entry.path = filepath.FromSlash(name)
+		if entry.render = strings.HasSuffix(name, packTemplateSuffix); entry.render {
+			entry.path = strings.TrimSuffix(entry.path, packTemplateSuffix)
+		}
+		if entry.mode&0111 != 0 {
+			entry.mode = 0755
+		} else {
+			entry.mode = 0644
+		}
+		pack.files = append(pack.files, entry)
+	}
+	slices.SortFunc(pack.files, func(a, b packFile) int {
+		return strings.Compare(a.path, b.path)
+	})
+
+	if !slices.ContainsFunc(pack.files, func(file packFile) bool {
+		return file.path == filepath.Join("server", "main.go")
+	}) {
+		return nil, fmt.Errorf("%w: template pack %s has no server/main.go (or server/main.go.tmpl)", ErrInvalidSpec, location)
+	}
+	return pack, nil
+}
+
+// parameterValues tells the values of the template's parameters for a
+// project spec: the given ones, or the defaults. The required ones must
+// be given, and the given ones must exist.
+func (pack *templatePack) parameterValues(spec *ProjectSpec) (map[string]string, error) {
+	values := map[string]string{}
+	for _, parameter := range pack.manifest.Parameters {
+		if value, ok := spec.Parameters[parameter.Name]; ok {
+			values[parameter.Name] = value
+		} else if parameter.Required {
+			return nil, fmt.Errorf("%w: template %s requires the %s parameter (use -param %s=<value>)", ErrUsage, pack.manifest.Name, parameter.Name, parameter.Name)
+		} else {
+			values[parameter.Name] = parameter.Default
+		}
+	}
+	for name := range spec.Parameters {
+		if _, ok := values[name]; !ok {
+			return nil, fmt.Errorf("%w: template %s has no %s parameter", ErrUsage, pack.manifest.Name, name)
+		}
+	}
+	return values, nil
+}
+
+// extraServices renders the extra docker-compose services of the pack,
+// indented to be placed in the services section.
+func (pack *templatePack) extraServices(reserved []string) (string, error) {
+	services := pack.manifest.Services
+	if services.Kind == 0 || len(services.Content) == 0 {
+		return "", nil
+	}
+	for index := 0; index < len(services.Content); index += 2 {
+		if name := services.Content[index].Value; slices.Contains(reserved, name) {
+			return "", fmt.Errorf("%w: template pack %s defines the %s service, which is reserved", ErrInvalidSpec, pack.manifest.Name, name)
+		}
+	}
+
+	content, err := marshalYAML(&services)
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(strings.TrimRight(content, "\n"), "\n")
+	for index := range lines {
+		lines[index] = "  " + lines[index]
+	}
+	return "\n" + strings.Join(lines, "\n"), nil
+}
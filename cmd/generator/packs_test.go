@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/AlephVault/golang-windrose-http-storage-generator/cmd/generator/templates"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSingleFilePacksAreCopied(t *testing.T) {
+	custom := filepath.Join(t.TempDir(), "main.go")
+	contents := "package main\n\nconst greeting = \"{{ .Name }}\"\n\nfunc main() { _ = greeting }\n"
+	if err := os.WriteFile(custom, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{custom: contents}
+	for _, template := range templates.Builtin {
+		cases[template.Key] = template.Contents
+	}
+	for location, want := range cases {
+		t.Run(filepath.Base(location), func(t *testing.T) {
+			pack, err := loadTemplatePack(location)
+			if err != nil {
+				t.Fatalf("loadTemplatePack: %v", err)
+			}
+			plan := &filePlan{}
+			if err := makeAppFiles(plan, defaultProjectSpec(), pack); err != nil {
+				t.Fatalf("makeAppFiles: %v", err)
+			}
+			if len(plan.files) != 1 || plan.files[0].contents != want {
+				t.Errorf("the app template was not copied as it is")
+			}
+		})
+	}
+}
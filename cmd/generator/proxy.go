@@ -0,0 +1,58 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+var proxyCaddyFileContentsTemplate = strings.TrimSpace(`
+{
+	# Plain HTTP is not served at all: the clients must use TLS, so
+	# the API keys are never sent in cleartext.
+	auto_https disable_redirects
+{{- if not .TLS.CertFile }}
+	# The root certificate is trusted by the clients, not the proxy.
+	skip_install_trust
+{{- end }}
+}
+
+{{ .TLS.Host }}:443 {
+{{- if .TLS.CertFile }}
+	tls /certs/tls.crt /certs/tls.key
+{{- else }}
+	# A self-signed certificate, for development. The clients must
+	# trust the root certificate, which is in:
+	#   .tmp/caddy/caddy/pki/authorities/local/root.crt
+	tls internal
+{{- end }}
+	reverse_proxy http:80
+}
+`)
+
+// composeHostPath makes a path relative to the project suitable as a
+// docker-compose volume source (which must start with . or /).
+func composeHostPath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return "./" + filepath.ToSlash(filepath.Clean(path))
+}
+
+// CertVolume tells the host path of the certificate, as a volume source.
+func (spec TLSSpec) CertVolume() string {
+	return composeHostPath(spec.CertFile)
+}
+
+// KeyVolume tells the host path of the private key, as a volume source.
+func (spec TLSSpec) KeyVolume() string {
+	return composeHostPath(spec.KeyFile)
+}
+
+// makeProxyFile makes the configuration of the TLS reverse proxy, if
+// enabled.
+func makeProxyFile(plan *filePlan, spec *ProjectSpec) error {
+	if !spec.TLS.Enabled {
+		return nil
+	}
+	return plan.render(filepath.Join("proxy", "Caddyfile"), proxyCaddyFileContentsTemplate, spec, 0644)
+}
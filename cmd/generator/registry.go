@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+)
+
+// installedTemplatePrefix is the prefix of the keys of the installed
+// templates (e.g. "local:rpg").
+const installedTemplatePrefix = "local:"
+
+// templateCacheEnv is the environment variable which, if set, tells
+// the directory of the installed templates.
+const templateCacheEnv = "WINDROSE_TEMPLATE_CACHE"
+
+// templateNameRegex matches the valid names of installed templates.
+var templateNameRegex = regexp.MustCompile("^[a-z0-9][a-z0-9_-]*$")
+
+// templateCacheDir tells the directory of the installed templates:
+// the one in $WINDROSE_TEMPLATE_CACHE or, by default, a directory in
+// the user's cache directory.
+func templateCacheDir() (string, error) {
+	if dir := os.Getenv(templateCacheEnv); dir != "" {
+		return dir, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find the template cache directory (set %s): %w", templateCacheEnv, err)
+	}
+	return filepath.Join(dir, "windrose-generator", "templates"), nil
+}
+
+// loadInstalledTemplate loads an installed template by its name.
+func loadInstalledTemplate(name string) (*templatePack, error) {
+	dir, err := templateCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	location := filepath.Join(dir, name)
+	if !templateNameRegex.MatchString(name) {
+		return nil, fmt.Errorf("%w: invalid template name: %s", ErrTemplateNotFound, name)
+	} else if stat, err := os.Stat(location); os.IsNotExist(err) || err == nil && !stat.IsDir() {
+		return nil, fmt.Errorf("%w: %s%s is not installed (see list-templates)", ErrTemplateNotFound, installedTemplatePrefix, name)
+	} else if err != nil {
+		return nil, &PathError{"access installed template", location, err}
+	}
+
+	entries, err := readPackEntries(location, true)
+	if err != nil {
+		return nil, err
+	}
+	pack, err := newTemplatePack(location, entries)
+	if err != nil {
+		return nil, err
+	}
+	pack.manifest.Name = name
+	return pack, nil
+}
+
+// installedTemplates lists the names of the installed templates.
+func installedTemplates() ([]string, error) {
+	dir, err := templateCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, &PathError{"read template cache", dir, err}
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && templateNameRegex.MatchString(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// installTemplate installs a template pack (a directory or archive)
+// into the template cache, under the given name or, by default, the
+// name in its manifest. An installed template with the same name is
+// only replaced if forced. It returns the key of the template.
+func installTemplate(location, name string, force bool) (string, error) {
+	stat, err := os.Stat(location)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("%w: %s does not exist", ErrTemplateNotFound, location)
+	} else if err != nil {
+		return "", &PathError{"access template", location, err}
+	} else if !stat.IsDir() && !isArchive(location) {
+		return "", fmt.Errorf("%w: %s is not a template pack directory or archive (.zip, .tar.gz or .tgz)", ErrUsage, location)
+	}
+
+	entries, err := readPackEntries(location, stat.IsDir())
+	if err != nil {
+		return "", err
+	}
+	pack, err := newTemplatePack(location, entries)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		name = pack.manifest.Name
+	}
+	if !templateNameRegex.MatchString(name) {
+		return "", fmt.Errorf("%w: invalid template name %q (use -name to choose another one)", ErrInvalidSpec, name)
+	}
+
+	dir, err := templateCacheDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", &PathError{"create template cache", dir, err}
+	}
+	target := filepath.Join(dir, name)
+	files := []plannedFile{}
+	for _, source := range pack.sources {
+		files = append(files, plannedFile{filepath.FromSlash(source.path), source.contents, source.mode})
+	}
+
+	// An existing template is moved aside, and restored if the new
+	// one could not be written.
+	if _, err := os.Stat(target); err == nil {
+		if !force {
+			return "", fmt.Errorf("%w: template %s%s is already installed; use -force to replace it", ErrPathExists, installedTemplatePrefix, name)
+		}
+		previous, err := os.MkdirTemp(dir, "."+name+"-previous-")
+		if err != nil {
+			return "", &PathError{"create directory in", dir, err}
+		}
+		defer os.RemoveAll(previous)
+		if err := os.Rename(target, filepath.Join(previous, name)); err != nil {
+			return "", &PathError{"move installed template", target, err}
+		}
+		if err := writeNewProject(target, files); err != nil {
+			if err := os.Rename(filepath.Join(previous, name), target); err != nil {
+				_, _ = fmt.Fprintln(os.Stderr, "Could not restore "+target+":", err)
+			}
+			return "", err
+		}
+	} else if !os.IsNotExist(err) {
+		return "", &PathError{"access installed template", target, err}
+	} else if err := writeNewProject(target, files); err != nil {
+		return "", err
+	}
+	return installedTemplatePrefix + name, nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// renderTemplate renders a template's text against the given data
+// (typically, the project spec). Missing keys are treated as errors,
+// so typos in the templates are detected on generation.
+func renderTemplate(name, text string, data any) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("%w: could not parse template %s: %w", ErrInvalidSpec, name, err)
+	}
+
+	builder := strings.Builder{}
+	if err := tmpl.Execute(&builder, data); err != nil {
+		return "", fmt.Errorf("%w: could not render template %s: %w", ErrInvalidSpec, name, err)
+	}
+	return builder.String(), nil
+}
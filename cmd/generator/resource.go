@@ -0,0 +1,26 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// resourceNameRegex matches the valid resource names, as exposed in
+// the API.
+var resourceNameRegex = regexp.MustCompile("^[a-z][a-z0-9-]*$")
+
+// modelNameFor derives a model type name from a resource name
+// (e.g. "weapon-kinds" becomes "WeaponKind").
+func modelNameFor(name string) string {
+	builder := strings.Builder{}
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool {
+		return r == '-' || r == '_'
+	}) {
+		builder.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	model := builder.String()
+	if len(model) > 1 && strings.HasSuffix(model, "s") && !strings.HasSuffix(model, "ss") {
+		model = model[:len(model)-1]
+	}
+	return model
+}
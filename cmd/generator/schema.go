@@ -0,0 +1,444 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var resourcesFileContentsTemplate = strings.TrimSpace(`
+// Code generated by the windrose generator from {{ .Source }}. DO NOT EDIT.
+
+package main
+
+import (
+{{- range .Imports }}
+	{{ printf "%q" . }}
+{{- end }}
+)
+{{ range .Structs }}
+// {{ .Comment }}
+type {{ .Name }} struct {
+{{- range .Fields }}
+	{{ .Name }} {{ .Type }} {{ .Tag }}
+{{- end }}
+}
+{{ end }}
+func init() {
+{{- range .Resources }}
+	extraResources[{{ printf "%q" .Name }}] = dsl.Resource{
+		Type: dsl.ListResource,
+		TableRef: dsl.TableRef{
+			Db:         {{ printf "%q" .Db }},
+			Collection: {{ printf "%q" .Collection }},
+		},
+		ModelType:      dsl.ModelType[{{ .Model }}],
+		SoftDelete:     {{ .SoftDelete }},
+		ListMaxResults: {{ .ListMaxResults }},
+{{- if .Projection }}
+		Projection: bson.M{ {{- range $index, $field := .Projection }}{{ if $index }}, {{ end }}{{ printf "%q" $field }}: 1{{ end -}} },
+{{- end }}
+{{- if .Indexes }}
+		Indexes: map[string]dsl.Index{
+{{- range $name, $index := .Indexes }}
+			{{ printf "%q" $name }}: {
+				Unique: {{ $index.Unique }},
+				Fields: []string{ {{- range $position, $field := $index.Fields }}{{ if $position }}, {{ end }}{{ printf "%q" $field }}{{ end -}} },
+			},
+{{- end }}
+		},
+{{- end }}
+	}
+{{- end }}
+}
+`)
+
+// schemaFileName is the default name of the schema in the project root.
+const schemaFileName = "schema.yaml"
+
+var typeNameRegex = regexp.MustCompile("^[A-Z][a-zA-Z0-9_]*$")
+var typeAliases = map[*regexp.Regexp]string{
+	regexp.MustCompile(`\bobjectId\b`): "primitive.ObjectID",
+	regexp.MustCompile(`\bdatetime\b`): "time.Time",
+}
+
+// SchemaField describes a field of a model or type.
+type SchemaField struct {
+	// Name is the Go name of the field.
+	Name string `yaml:"name" json:"name"`
+	// Type is the Go type of the field. The "objectId" and "datetime"
+	// aliases stand for primitive.ObjectID and time.Time.
+	Type string `yaml:"type" json:"type"`
+	// Bson is the bson name of the field (default: snake-cased Name).
+	Bson string `yaml:"bson,omitempty" json:"bson,omitempty"`
+	// JSON is the json name of the field (default: the bson name).
+	JSON string `yaml:"json,omitempty" json:"json,omitempty"`
+	// Validate is the validation tag of the field.
+	Validate string `yaml:"validate,omitempty" json:"validate,omitempty"`
+}
+
+// SchemaType describes an auxiliary type (e.g. an embedded struct).
+type SchemaType struct {
+	// Name is the Go name of the type.
+	Name string `yaml:"name" json:"name"`
+	// Fields are the fields of the type.
+	Fields []SchemaField `yaml:"fields" json:"fields"`
+}
+
+// SchemaIndex describes an index of a resource.
+type SchemaIndex struct {
+	// Unique tells whether the index is unique.
+	Unique bool `yaml:"unique,omitempty" json:"unique,omitempty"`
+	// Fields are the bson names of the indexed fields.
+	Fields []string `yaml:"fields" json:"fields"`
+}
+
+// SchemaResource describes a list resource and its model.
+type SchemaResource struct {
+	// Name is the resource name, as exposed in the API.
+	Name string `yaml:"name" json:"name"`
+	// Model is the Go name of the model (default: derived from Name).
+	Model string `yaml:"model,omitempty" json:"model,omitempty"`
+	// Db is the database of the collection (default: "universe").
+	Db string `yaml:"db,omitempty" json:"db,omitempty"`
+	// Collection is the collection (default: the resource name).
+	Collection string `yaml:"collection,omitempty" json:"collection,omitempty"`
+	// SoftDelete tells whether the resource uses soft-delete (default: true).
+	SoftDelete *bool `yaml:"softDelete,omitempty" json:"softDelete,omitempty"`
+	// ListMaxResults is the maximum number of results per page (default: 20).
+	ListMaxResults uint `yaml:"listMaxResults,omitempty" json:"listMaxResults,omitempty"`
+	// Projection lists the bson fields retrieved when listing.
+	Projection []string `yaml:"projection,omitempty" json:"projection,omitempty"`
+	// Fields are the fields of the model, save for the ID.
+	Fields []SchemaField `yaml:"fields,omitempty" json:"fields,omitempty"`
+	// Indexes are the indexes of the collection, by name.
+	Indexes map[string]SchemaIndex `yaml:"indexes,omitempty" json:"indexes,omitempty"`
+}
+
+// Schema is the declarative model of a project's resources.
+type Schema struct {
+	// Types are the auxiliary types used by the models.
+	Types []SchemaType `yaml:"types,omitempty" json:"types,omitempty"`
+	// Resources are the resources to generate.
+	Resources []SchemaResource `yaml:"resources" json:"resources"`
+}
+
+// goField is a field, ready to be rendered.
+type goField struct {
+	Name, Type, Tag string
+}
+
+// goStruct is a struct, ready to be rendered.
+type goStruct struct {
+	Name, Comment string
+	Fields        []goField
+}
+
+// resourcesFileData is the data the resources file is rendered with.
+type resourcesFileData struct {
+	Source    string
+	Imports   []string
+	Structs   []goStruct
+	Resources []SchemaResource
+}
+
+// snakeCase converts a Go name to snake case (e.g. "ScopeID"
+// becomes "scope_id").
+func snakeCase(name string) string {
+	runes := []rune(name)
+	builder := strings.Builder{}
+	for index, r := range runes {
+		if unicode.IsUpper(r) && index > 0 {
+			previous := runes[index-1]
+			nextIsLower := index+1 < len(runes) && unicode.IsLower(runes[index+1])
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) || (unicode.IsUpper(previous) && nextIsLower) {
+				builder.WriteRune('_')
+			}
+		}
+		builder.WriteRune(unicode.ToLower(r))
+	}
+	return builder.String()
+}
+
+// schemaError creates an error about an invalid schema.
+func schemaError(format string, args ...any) error {
+	return fmt.Errorf("%w: "+format, append([]any{ErrInvalidSpec}, args...)...)
+}
+
+// parseSchema parses the contents of a schema file (YAML or JSON).
+func parseSchema(schemaPath string, content []byte) (*Schema, error) {
+	schema := &Schema{}
+	if err := yaml.Unmarshal(content, schema); err != nil {
+		return nil, schemaError("could not parse schema file %s: %w", schemaPath, err)
+	}
+	return schema, nil
+}
+
+// loadSchema loads a schema file (YAML or JSON).
+func loadSchema(schemaPath string) (*Schema, error) {
+	content, err := os.ReadFile(schemaPath)
+	if err != nil {
+		return nil, &PathError{"read schema file", schemaPath, err}
+	}
+	return parseSchema(schemaPath, content)
+}
+
+// normalizeFields validates the fields of a type or model, and sets
+// their default values. Models have their ID field already defined.
+func normalizeFields(owner string, withID bool, fields []SchemaField) error {
+	names := map[string]bool{"ID": withID}
+	for index := range fields {
+		field := &fields[index]
+		if !typeNameRegex.MatchString(field.Name) {
+			return schemaError("invalid field name in %s: %q", owner, field.Name)
+		}
+		if names[field.Name] {
+			return schemaError("duplicate field in %s: %s", owner, field.Name)
+		}
+		names[field.Name] = true
+		if strings.TrimSpace(field.Type) == "" {
+			return schemaError("missing type for field %s.%s", owner, field.Name)
+		}
+		if field.Bson == "" {
+			field.Bson = snakeCase(field.Name)
+		}
+		if field.JSON == "" {
+			field.JSON = field.Bson
+		}
+	}
+	return nil
+}
+
+// normalize validates the schema and sets the default values.
+func (schema *Schema) normalize() error {
+	typeNames := map[string]bool{}
+	claimTypeName := func(name string) error {
+		if !typeNameRegex.MatchString(name) {
+			return schemaError("invalid type name in schema: %q", name)
+		}
+		if typeNames[name] {
+			return schemaError("duplicate type name in schema: %s", name)
+		}
+		typeNames[name] = true
+		return nil
+	}
+
+	for index := range schema.Types {
+		schemaType := &schema.Types[index]
+		if err := claimTypeName(schemaType.Name); err != nil {
+			return err
+		}
+		if err := normalizeFields(schemaType.Name, false, schemaType.Fields); err != nil {
+			return err
+		}
+	}
+
+	resourceNames := map[string]bool{}
+	for index := range schema.Resources {
+		resource := &schema.Resources[index]
+		if !resourceNameRegex.MatchString(resource.Name) {
+			return schemaError("invalid resource name %q: it must be lowercase, with dashes", resource.Name)
+		}
+		if resourceNames[resource.Name] {
+			return schemaError("duplicate resource in schema: %s", resource.Name)
+		}
+		resourceNames[resource.Name] = true
+		if resource.Model == "" {
+			resource.Model = modelNameFor(resource.Name)
+		}
+		if err := claimTypeName(resource.Model); err != nil {
+			return err
+		}
+		if resource.Db == "" {
+			resource.Db = "universe"
+		}
+		if resource.Collection == "" {
+			resource.Collection = resource.Name
+		}
+		if resource.SoftDelete == nil {
+			softDelete := true
+			resource.SoftDelete = &softDelete
+		}
+		if resource.ListMaxResults == 0 {
+			resource.ListMaxResults = 20
+		}
+		if err := normalizeFields(resource.Model, true, resource.Fields); err != nil {
+			return err
+		}
+		for name, index := range resource.Indexes {
+			if len(index.Fields) == 0 {
+				return schemaError("index %s of resource %s has no fields", name, resource.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// goStructFor converts fields to a struct ready to be rendered.
+func goStructFor(name, comment string, withID bool, fields []SchemaField) goStruct {
+	result := goStruct{Name: name, Comment: comment}
+	if withID {
+		result.Fields = append(result.Fields, goField{
+			Name: "ID", Type: "primitive.ObjectID", Tag: "`bson:\"_id,omitempty\" json:\"_id,omitempty\"`",
+		})
+	}
+	for _, field := range fields {
+		fieldType := field.Type
+		for regex, replacement := range typeAliases {
+			fieldType = regex.ReplaceAllString(fieldType, replacement)
+		}
+		tag := "bson:" + strconv.Quote(field.Bson) + " json:" + strconv.Quote(field.JSON)
+		if field.Validate != "" {
+			tag += " validate:" + strconv.Quote(field.Validate)
+		}
+		result.Fields = append(result.Fields, goField{Name: field.Name, Type: fieldType, Tag: "`" + tag + "`"})
+	}
+	return result
+}
+
+// renderResourcesFile renders the Go source of the schema's models
+// and resources. The source is gofmt-ed.
+func renderResourcesFile(source string, schema *Schema) (string, error) {
+	data := resourcesFileData{Source: source, Resources: schema.Resources}
+	for _, schemaType := range schema.Types {
+		data.Structs = append(data.Structs, goStructFor(
+			schemaType.Name, schemaType.Name+" is an auxiliary type of the schema.", false, schemaType.Fields,
+		))
+	}
+	for _, resource := range schema.Resources {
+		data.Structs = append(data.Structs, goStructFor(
+			resource.Model, resource.Model+" is the model of the \""+resource.Name+"\" resource.", true, resource.Fields,
+		))
+	}
+
+	// Only the used packages are imported.
+	imports := map[string]bool{"github.com/AlephVault/golang-standard-http-mongodb-storage/core/dsl": true}
+	for _, item := range data.Structs {
+		for _, field := range item.Fields {
+			if strings.Contains(field.Type, "primitive.") {
+				imports["go.mongodb.org/mongo-driver/bson/primitive"] = true
+			}
+			if strings.Contains(field.Type, "time.") {
+				imports["time"] = true
+			}
+		}
+	}
+	for _, resource := range schema.Resources {
+		if len(resource.Projection) > 0 {
+			imports["go.mongodb.org/mongo-driver/bson"] = true
+		}
+	}
+	for path := range imports {
+		data.Imports = append(data.Imports, path)
+	}
+	sort.Strings(data.Imports)
+
+	contents, err := renderTemplate("server/resources.go", resourcesFileContentsTemplate, data)
+	if err != nil {
+		return "", err
+	}
+	formatted, err := format.Source([]byte(contents))
+	if err != nil {
+		return "", schemaError("the schema %s produces invalid Go code: %w", source, err)
+	}
+	return string(formatted), nil
+}
+
+// makeResourcesFile makes the resources file of the server from the
+// project's schema, if any.
+func makeResourcesFile(plan *filePlan, spec *ProjectSpec) error {
+	if spec.Schema == "" {
+		return nil
+	}
+
+	schema, err := loadSchema(spec.Schema)
+	if err != nil {
+		return err
+	}
+	return planResourcesFile(plan, spec.Schema, schema)
+}
+
+// planResourcesFile normalizes a schema, and adds the resources file
+// rendered from it to the plan.
+func planResourcesFile(plan *filePlan, schemaPath string, schema *Schema) error {
+	if err := schema.normalize(); err != nil {
+		return err
+	}
+	contents, err := renderResourcesFile(filepath.Base(schemaPath), schema)
+	if err != nil {
+		return err
+	}
+	plan.add(filepath.Join("server", "resources.go"), contents, 0644)
+	return nil
+}
+
+// addSchemaResource appends a resource to the contents of a schema file
+// (empty, if it does not exist yet), and returns the new contents. YAML
+// schemas keep their comments and layout. Nothing is written.
+func addSchemaResource(schemaPath string, content []byte, resource SchemaResource) (string, error) {
+	// First, check the resulting schema is valid.
+	checked := resource
+	checked.Fields = slices.Clone(resource.Fields)
+	schema, err := parseSchema(schemaPath, content)
+	if err != nil {
+		return "", err
+	}
+	schema.Resources = append(schema.Resources, checked)
+	if err := schema.normalize(); err != nil {
+		return "", err
+	}
+
+	// JSON schemas are just re-serialized.
+	if filepath.Ext(schemaPath) == ".json" {
+		schema, _ := parseSchema(schemaPath, content)
+		schema.Resources = append(schema.Resources, resource)
+		serialized, err := json.MarshalIndent(schema, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("could not serialize schema: %w", err)
+		}
+		return string(serialized) + "\n", nil
+	}
+
+	// YAML schemas get the resource node appended to the document.
+	root := yaml.Node{}
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return "", schemaError("could not parse schema file %s: %w", schemaPath, err)
+	}
+	if root.Kind == 0 {
+		root = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	mapping := root.Content[0]
+	if mapping.Kind != yaml.MappingNode {
+		return "", schemaError("invalid schema file %s: it must be a mapping", schemaPath)
+	}
+	resourcesIndex := -1
+	for index := 0; index+1 < len(mapping.Content); index += 2 {
+		if mapping.Content[index].Value == "resources" {
+			resourcesIndex = index + 1
+		}
+	}
+	if resourcesIndex == -1 {
+		mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "resources"}, nil)
+		resourcesIndex = len(mapping.Content) - 1
+	}
+	if mapping.Content[resourcesIndex] == nil || mapping.Content[resourcesIndex].Kind != yaml.SequenceNode {
+		mapping.Content[resourcesIndex] = &yaml.Node{Kind: yaml.SequenceNode}
+	}
+	node := &yaml.Node{}
+	if err := node.Encode(resource); err != nil {
+		return "", fmt.Errorf("could not serialize resource: %w", err)
+	}
+	resources := mapping.Content[resourcesIndex]
+	resources.Content = append(resources.Content, node)
+
+	return marshalYAML(&root)
+}
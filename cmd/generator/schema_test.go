@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSnakeCase(t *testing.T) {
+	cases := map[string]string{
+		"Name":       "name",
+		"ScopeID":    "scope_id",
+		"HTTPServer": "http_server",
+		"Level2Name": "level2_name",
+		"CreatedAt":  "created_at",
+	}
+	for name, want := range cases {
+		if got := snakeCase(name); got != want {
+			t.Errorf("snakeCase(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestSchemaNormalize(t *testing.T) {
+	cases := []struct {
+		name   string
+		schema string
+		// want is a substring of the error, or empty if none is expected.
+		want string
+	}{
+		{"valid", "resources:\n  - name: weapon-kinds\n    fields:\n      - {name: Power, type: int}\n", ""},
+		{"invalid resource name", "resources:\n  - name: Weapons\n", "invalid resource name"},
+		{"duplicate resource", "resources:\n  - name: weapons\n  - name: weapons\n", "duplicate resource"},
+		{"duplicate type", "types:\n  - name: Weapon\nresources:\n  - name: weapons\n", "duplicate type name"},
+		{"invalid field name", "resources:\n  - name: weapons\n    fields:\n      - {name: power, type: int}\n", "invalid field name"},
+		{"field named ID", "resources:\n  - name: weapons\n    fields:\n      - {name: ID, type: int}\n", "duplicate field"},
+		{"missing type", "resources:\n  - name: weapons\n    fields:\n      - {name: Power}\n", "missing type"},
+		{"empty index", "resources:\n  - name: weapons\n    indexes:\n      by_power: {unique: true}\n", "has no fields"},
+	}
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			schema, err := parseSchema("schema.yaml", []byte(testCase.schema))
+			if err != nil {
+				t.Fatalf("parseSchema: %v", err)
+			}
+			err = schema.normalize()
+			if testCase.want == "" {
+				if err != nil {
+					t.Fatalf("normalize: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidSpec) || !strings.Contains(err.Error(), testCase.want) {
+				t.Errorf("normalize: got %v, want an invalid spec error about %q", err, testCase.want)
+			}
+		})
+	}
+}
+
+func TestSchemaNormalizeDefaults(t *testing.T) {
+	schema, err := parseSchema("schema.yaml", []byte("resources:\n  - name: weapon-kinds\n    fields:\n      - {name: MaxLevel, type: int}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := schema.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	resource := schema.Resources[0]
+	if resource.Model != "WeaponKind" || resource.Db != "universe" || resource.Collection != "weapon-kinds" ||
+		resource.SoftDelete == nil || !*resource.SoftDelete || resource.ListMaxResults != 20 {
+		t.Errorf("unexpected resource defaults: %+v", resource)
+	}
+	if field := resource.Fields[0]; field.Bson != "max_level" || field.JSON != "max_level" {
+		t.Errorf("unexpected field defaults: %+v", field)
+	}
+}
+
+func TestRenderResourcesFile(t *testing.T) {
+	cases := []struct {
+		name    string
+		schema  string
+		want    string
+		wantErr bool
+	}{
+		{"model", "resources:\n  - name: weapons\n    fields:\n      - {name: Power, type: int, validate: min=0}\n",
+			"`bson:\"power\" json:\"power\" validate:\"min=0\"`", false},
+		{"aliases", "resources:\n  - name: weapons\n    fields:\n      - {name: Owner, type: objectId}\n      - {name: ForgedAt, type: datetime}\n",
+			"\"time\"", false},
+		{"invalid type", "resources:\n  - name: weapons\n    fields:\n      - {name: Power, type: \"map[string\"}\n",
+			"", true},
+	}
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			schema, err := parseSchema("schema.yaml", []byte(testCase.schema))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := schema.normalize(); err != nil {
+				t.Fatal(err)
+			}
+			contents, err := renderResourcesFile("schema.yaml", schema)
+			if testCase.wantErr {
+				if !errors.Is(err, ErrInvalidSpec) {
+					t.Errorf("renderResourcesFile: got %v, want %v", err, ErrInvalidSpec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("renderResourcesFile: %v", err)
+			}
+			if !strings.Contains(contents, testCase.want) {
+				t.Errorf("renderResourcesFile: %q not found in:\n%s", testCase.want, contents)
+			}
+		})
+	}
+}
+
+func TestAddSchemaResource(t *testing.T) {
+	resource := SchemaResource{Name: "armors", Fields: []SchemaField{{Name: "Defense", Type: "int"}}}
+	cases := []struct {
+		name    string
+		path    string
+		content string
+		// want are substrings of the new contents.
+		want []string
+		// wantErr is a substring of the error, or empty if none is expected.
+		wantErr string
+	}{
+		{"new YAML schema", "schema.yaml", "", []string{"resources:", "name: armors", "name: Defense"}, ""},
+		{"YAML schema keeps comments", "schema.yaml", "# The game's resources.\nresources:\n  # Weapons.\n  - name: weapons\n",
+			[]string{"# The game's resources.", "# Weapons.", "name: weapons", "name: armors"}, ""},
+		{"JSON schema", "schema.json", "{\"resources\": [{\"name\": \"weapons\"}]}\n",
+			[]string{"\"name\": \"weapons\"", "\"name\": \"armors\""}, ""},
+		{"duplicate resource", "schema.yaml", "resources:\n  - name: armors\n", nil, "duplicate resource"},
+		{"not a mapping", "schema.yaml", "- armors\n", nil, "could not parse"},
+	}
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			contents, err := addSchemaResource(testCase.path, []byte(testCase.content), resource)
+			if testCase.wantErr != "" {
+				if !errors.Is(err, ErrInvalidSpec) || !strings.Contains(err.Error(), testCase.wantErr) {
+					t.Errorf("addSchemaResource: got %v, want an invalid spec error about %q", err, testCase.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("addSchemaResource: %v", err)
+			}
+			for _, want := range testCase.want {
+				if !strings.Contains(contents, want) {
+					t.Errorf("addSchemaResource: %q not found in:\n%s", want, contents)
+				}
+			}
+			// The new contents must be a valid schema, with the resource.
+			schema, err := parseSchema(testCase.path, []byte(contents))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := schema.normalize(); err != nil {
+				t.Fatal(err)
+			}
+			if last := schema.Resources[len(schema.Resources)-1]; last.Name != "armors" {
+				t.Errorf("last resource: got %s, want armors", last.Name)
+			}
+		})
+	}
+}
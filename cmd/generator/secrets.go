@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// redactedSecret stands for a secret in the printed contents and diffs.
+const redactedSecret = "<redacted>"
+
+// wellKnownSecrets are the secrets the generator used to default to.
+// They must never be used in a running stack.
+var wellKnownSecrets = []string{"p455w0rd", "sample-abcdef"}
+
+// randomSecret generates a URL-safe secret out of the given number of
+// cryptographically random bytes.
+func randomSecret(size int) (string, error) {
+	buffer := make([]byte, size)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", fmt.Errorf("could not generate a random secret: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buffer), nil
+}
+
+// fillSecrets generates the secrets the spec does not have yet. It
+// returns a message for each generated secret, to be shown once.
+func fillSecrets(spec *ProjectSpec) ([]string, error) {
+	messages := []string{}
+	if spec.Mongo.Password == "" {
+		secret, err := randomSecret(24)
+		if err != nil {
+			return nil, err
+		}
+		spec.Mongo.Password = secret
+		messages = append(messages, "Generated MongoDB password: "+secret)
+	}
+	if spec.Server.APIKey == "" {
+		secret, err := randomSecret(32)
+		if err != nil {
+			return nil, err
+		}
+		spec.Server.APIKey = secret
+		messages = append(messages, "Generated server API key: "+secret)
+	}
+	if spec.Metrics.Services && spec.Metrics.GrafanaPassword == "" {
+		secret, err := randomSecret(24)
+		if err != nil {
+			return nil, err
+		}
+		spec.Metrics.GrafanaPassword = secret
+		messages = append(messages, "Generated Grafana admin password: "+secret)
+	}
+	if spec.Mongo.ReplicaSet.Members != 0 && spec.Mongo.ReplicaSet.Key == "" {
+		// Keyfiles only take base64 characters, so it is hex-encoded.
+		buffer := make([]byte, 48)
+		if _, err := rand.Read(buffer); err != nil {
+			return nil, fmt.Errorf("could not generate a random secret: %w", err)
+		}
+		spec.Mongo.ReplicaSet.Key = hex.EncodeToString(buffer)
+	}
+	return messages, nil
+}
+
+// secretValues lists the secrets of a spec: the values of the fields
+// which are not stored in the manifest but in the .env file.
+func secretValues(spec *ProjectSpec) []string {
+	secrets := []string{}
+	var collect func(value reflect.Value)
+	collect = func(value reflect.Value) {
+		for index := range value.NumField() {
+			field, fieldType := value.Field(index), value.Type().Field(index)
+			if field.Kind() == reflect.Struct {
+				collect(field)
+			} else if fieldType.Tag.Get("yaml") == "-" && field.Kind() == reflect.String && field.String() != "" {
+				secrets = append(secrets, field.String())
+			}
+		}
+	}
+	collect(reflect.ValueOf(spec).Elem())
+	return secrets
+}
+
+// redactSecrets replaces the given secrets in a text, so it can be
+// printed (e.g. into CI logs).
+func redactSecrets(text string, secrets []string) string {
+	replacements := []string{}
+	for _, secret := range secrets {
+		if secret != "" {
+			replacements = append(replacements, secret, redactedSecret)
+		}
+	}
+	return strings.NewReplacer(replacements...).Replace(text)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSecretValues(t *testing.T) {
+	spec := defaultProjectSpec()
+	spec.Mongo.Password = "mongo-secret"
+	spec.Server.APIKey = "api-secret"
+	spec.Mongo.ReplicaSet.Key = "replica-secret"
+	got := secretValues(spec)
+	slices.Sort(got)
+	if want := []string{"api-secret", "mongo-secret", "replica-secret"}; !slices.Equal(got, want) {
+		t.Errorf("secretValues() = %v, want %v", got, want)
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	secrets := []string{"mongo-secret", "api-secret", ""}
+	cases := []struct {
+		text string
+		want string
+	}{
+		{"DB_USER=admin\n", "DB_USER=admin\n"},
+		{"DB_PASS=mongo-secret\n", "DB_PASS=<redacted>\n"},
+		{"  SERVER_API_KEY: \"api-secret\"\n", "  SERVER_API_KEY: \"<redacted>\"\n"},
+		{"-DB_PASS=mongo-secret\n+DB_PASS=api-secret\n", "-DB_PASS=<redacted>\n+DB_PASS=<redacted>\n"},
+	}
+	for _, testCase := range cases {
+		if got := redactSecrets(testCase.text, secrets); got != testCase.want {
+			t.Errorf("redactSecrets(%q) = %q, want %q", testCase.text, got, testCase.want)
+		}
+	}
+}
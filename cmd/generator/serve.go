@@ -0,0 +1,307 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+var serveFileContentsTemplate = strings.TrimSpace(`
+// Code generated by the windrose generator. DO NOT EDIT.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/AlephVault/golang-standard-http-mongodb-storage/app"
+	"github.com/AlephVault/golang-standard-http-mongodb-storage/core/dsl"
+{{- if .Metrics.Enabled }}
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+{{- end }}
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// The build info, set on build with -ldflags (see the Dockerfile).
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildTime = "unknown"
+)
+
+// appAddress is the internal address the storage app listens on. The
+// server in front of it serves the operational endpoints, and proxies
+// the other requests to the app.
+const appAddress = "127.0.0.1:8079"
+
+// defaultShutdownTimeout is how long the in-flight requests are waited
+// for on shutdown, unless $SHUTDOWN_TIMEOUT tells otherwise.
+const defaultShutdownTimeout = 15 * time.Second
+
+// mongoClient is the app's MongoDB client, once it is set up.
+var mongoClient atomic.Pointer[mongo.Client]
+
+// trackClient keeps the app's MongoDB client, to tell the readiness.
+// It is meant to be called at the end of a successful setup, so a
+// failed one leaves the server unready.
+func trackClient(client *mongo.Client) {
+	mongoClient.Store(client)
+}
+
+// writeJSON writes a JSON response.
+func writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// healthzHandler tells the process is alive.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyzHandler tells whether the app is set up and MongoDB answers.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	client := mongoClient.Load()
+	if client == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "starting"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "mongodb-unavailable"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// versionHandler tells the build info.
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"version":   version,
+		"commit":    commit,
+		"buildTime": buildTime,
+		"go":        runtime.Version(),
+	})
+}
+
+// statusRecorder is a response writer which keeps the status code.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader keeps the status code, and writes it.
+func (recorder *statusRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+// resourceLabel tells the resource a request path is for: one of the
+// resources, or "other" (so the labels are bounded).
+func resourceLabel(resources map[string]dsl.Resource, path string) string {
+	name, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if _, ok := resources[name]; ok {
+		return name
+	}
+	return "other"
+}
+
+// shutdownTimeout tells how long the in-flight requests are waited for
+// on shutdown: the duration in $SHUTDOWN_TIMEOUT, or the default one.
+func shutdownTimeout() time.Duration {
+	if value, ok := os.LookupEnv("SHUTDOWN_TIMEOUT"); ok {
+		if timeout, err := time.ParseDuration(strings.TrimSpace(value)); err == nil && timeout > 0 {
+			return timeout
+		}
+		slog.Warn("Invalid SHUTDOWN_TIMEOUT; using the default one", "value", value, "default", defaultShutdownTimeout)
+	}
+	return defaultShutdownTimeout
+}
+
+// serve runs the app behind a server, listening on the given address,
+// which adds the operational endpoints: /healthz (liveness), /readyz
+// (readiness, also as /health) and /version.{{ if .Metrics.Enabled }} The metrics are served
+// apart, on metricsAddress.{{ end }} It returns when either fails or, on
+// SIGTERM or SIGINT, once shut down gracefully: it stops accepting
+// requests, waits for the in-flight ones (up to the shutdown timeout)
+// and disconnects from MongoDB.
+func serve(application *app.Application, settings *dsl.Settings, address string) error {
+	errs := make(chan error, 3)
+	go func() {
+		errs <- application.Run(appAddress)
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+	mux.HandleFunc("/health", readyzHandler)
+	mux.HandleFunc("/version", versionHandler)
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: appAddress})
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	proxy.Transport = transport
+	var handler http.Handler = proxy
+{{- if .Metrics.Enabled }}
+	handler = instrument(handler, settings.Resources)
+	go watchMongo(15 * time.Second)
+	metricsServer := &http.Server{Addr: metricsAddress, Handler: promhttp.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		errs <- metricsServer.ListenAndServe()
+	}()
+{{- end }}
+	mux.Handle("/", logRequests(handler, settings.Resources))
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+	select {
+	case err := <-errs:
+		return err
+	case received := <-signals:
+		slog.Info("Shutting down...", "signal", received.String())
+	}
+
+	// The app only listens on the loopback, and every request reaches
+	// it through this server, so the in-flight ones are done once this
+	// server is drained. The app itself cannot be stopped (the library
+	// only offers to run it): its connections are closed, and it stops
+	// with the process, once this function returns.
+	timeout := shutdownTimeout()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if err != nil {
+		slog.Warn("Some requests were not done on time", "timeout", timeout, "error", err)
+	}
+	transport.CloseIdleConnections()
+{{- if .Metrics.Enabled }}
+	_ = metricsServer.Shutdown(ctx)
+{{- end }}
+	if client := mongoClient.Load(); client != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := client.Disconnect(ctx); err != nil {
+			slog.Error("Could not disconnect from MongoDB", "error", err)
+		}
+	}
+	slog.Info("Shut down")
+	return nil
+}
+`) + "\n"
+
+var loggingFileContentsTemplate = strings.TrimSpace(`
+// Code generated by the windrose generator. DO NOT EDIT.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/AlephVault/golang-standard-http-mongodb-storage/core/dsl"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// requestIDHeader is the header of the request IDs. The given ones are
+// kept (e.g. from a proxy), and the missing ones are generated.
+const requestIDHeader = "X-Request-ID"
+
+// requestIDRegex matches the valid given request IDs.
+var requestIDRegex = regexp.MustCompile("^[A-Za-z0-9._-]{1,64}$")
+
+// init makes the default logger log JSON, at the level in $LOG_LEVEL
+// (debug, info, warn or error; info by default).
+func init() {
+	level := slog.LevelInfo
+	if value, ok := os.LookupEnv("LOG_LEVEL"); ok {
+		if err := level.UnmarshalText([]byte(strings.TrimSpace(value))); err != nil {
+			level = slog.LevelInfo
+		}
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})))
+}
+
+// newRequestID generates a random request ID.
+func newRequestID() string {
+	buffer := make([]byte, 8)
+	_, _ = rand.Read(buffer)
+	return hex.EncodeToString(buffer)
+}
+
+// apiKeyIdentity tells a redacted identity of the API key of a request:
+// a prefix of its hash, so the requests of a key can be told apart
+// without logging the key itself.
+func apiKeyIdentity(r *http.Request) string {
+	key := r.Header.Get("X-Api-Key")
+	if value, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		key = value
+	}
+	if key = strings.TrimSpace(key); key == "" {
+		return "none"
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+// logRequests gives an ID to each request to the app (also telling it
+// in the response), and logs it once served.
+func logRequests(next http.Handler, resources map[string]dsl.Resource) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if !requestIDRegex.MatchString(requestID) {
+			requestID = newRequestID()
+			r.Header.Set(requestIDHeader, requestID)
+		}
+		w.Header().Set(requestIDHeader, requestID)
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		level := slog.LevelInfo
+		if recorder.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(r.Context(), level, "request",
+			slog.String("request_id", requestID),
+			slog.String("resource", resourceLabel(resources, r.URL.Path)),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("api_key", apiKeyIdentity(r)),
+			slog.Int("status", recorder.status),
+			slog.Duration("duration", time.Since(start)),
+		)
+	})
+}
+`) + "\n"
+
+// makeServeFiles makes the server files that run the app behind the
+// operational endpoints (health, readiness and version), logging the
+// requests.
+func makeServeFiles(plan *filePlan, spec *ProjectSpec) error {
+	if err := plan.render(filepath.Join("server", "serve.go"), serveFileContentsTemplate, spec, 0644); err != nil {
+		return err
+	}
+	return plan.render(filepath.Join("server", "logging.go"), loggingFileContentsTemplate, spec, 0644)
+}
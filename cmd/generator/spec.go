@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MongoSpec stands for the settings of the MongoDB service.
+type MongoSpec struct {
+	// Port is the host port MongoDB is published on.
+	Port uint16 `yaml:"port"`
+	// User is the MongoDB root user.
+	User string `yaml:"user"`
+	// Password is the MongoDB root password. Being a secret, it is
+	// not stored in the manifest but in the .env file.
+	Password string `yaml:"-"`
+	// ReplicaSet holds the replica set settings.
+	ReplicaSet ReplicaSetSpec `yaml:"replicaSet"`
+}
+
+// ReplicaSetSpec stands for the settings of the MongoDB replica set,
+// which transactions need.
+type ReplicaSetSpec struct {
+	// Members is the number of members of the replica set: 1 or 3, or
+	// 0 for a standalone MongoDB (without replica set).
+	Members int `yaml:"members"`
+	// Name is the name of the replica set.
+	Name string `yaml:"name"`
+	// Key is the key the members authenticate each other with. Being
+	// a secret, it is not stored in the manifest but in the .env file.
+	Key string `yaml:"-"`
+}
+
+// MongoExpressSpec stands for the settings of the Mongo Express service.
+type MongoExpressSpec struct {
+	// Port is the host port Mongo Express is published on.
+	Port uint16 `yaml:"port"`
+}
+
+// HTTPSpec stands for the settings of the HTTP service.
+type HTTPSpec struct {
+	// Port is the host port the HTTP server is published on.
+	Port uint16 `yaml:"port"`
+}
+
+// TLSSpec stands for the settings of the reverse proxy which terminates
+// TLS in front of the HTTP service.
+type TLSSpec struct {
+	// Enabled tells whether the proxy is added. If so, the HTTP service
+	// is not published anymore: only the proxy is.
+	Enabled bool `yaml:"enabled"`
+	// Port is the host port the proxy is published on.
+	Port uint16 `yaml:"port"`
+	// Host is the host name the certificate is for.
+	Host string `yaml:"host"`
+	// CertFile is the path to the certificate (PEM, with the whole
+	// chain), relative to the project. Without it, a self-signed one
+	// is generated by the proxy, which is only fine for development.
+	CertFile string `yaml:"certFile,omitempty"`
+	// KeyFile is the path to the certificate's private key (PEM),
+	// relative to the project.
+	KeyFile string `yaml:"keyFile,omitempty"`
+}
+
+// MetricsSpec stands for the settings of the metrics of the server and
+// the monitoring services.
+type MetricsSpec struct {
+	// Enabled tells whether the server serves Prometheus metrics (in
+	// /metrics, on a port which is not published).
+	Enabled bool `yaml:"enabled"`
+	// Services tells whether Prometheus and Grafana services (with a
+	// dashboard of the metrics) are added to the compose file.
+	Services bool `yaml:"services"`
+	// PrometheusPort is the host port Prometheus is published on (in
+	// the development profile only).
+	PrometheusPort uint16 `yaml:"prometheusPort"`
+	// GrafanaPort is the host port Grafana is published on (only on
+	// the loopback interface, in the production profile).
+	GrafanaPort uint16 `yaml:"grafanaPort"`
+	// GrafanaPassword is the Grafana admin password. Being a secret,
+	// it is not stored in the manifest but in the .env file.
+	GrafanaPassword string `yaml:"-"`
+}
+
+// ServerSpec stands for the settings of the generated server app.
+type ServerSpec struct {
+	// APIKey is the default API key installed on first setup. Being
+	// a secret, it is not stored in the manifest but in the .env file.
+	APIKey string `yaml:"-"`
+	// Debug tells whether the rendered app runs in debug mode. If not
+	// set, it does unless in the production profile.
+	Debug *bool `yaml:"debug,omitempty"`
+	// Module is the Go module path of the server.
+	Module string `yaml:"module"`
+	// GoVersion is the Go version of the server's module, which is
+	// also the one of the image it is built with.
+	GoVersion string `yaml:"goVersion"`
+	// StorageVersion is the pinned version of the storage library.
+	StorageVersion string `yaml:"storageVersion"`
+	// Dependencies tells how the dependencies are resolved: on build
+	// ("tidy"), on generation ("resolved") or on generation and into
+	// vendor/ ("vendor").
+	Dependencies string `yaml:"dependencies"`
+	// ShutdownTimeout is how long the server waits for the in-flight
+	// requests on shutdown (e.g. 15s), before closing anyway.
+	ShutdownTimeout string `yaml:"shutdownTimeout"`
+}
+
+// GracePeriodSeconds tells how long the containers of the server are
+// given to stop before being killed: the shutdown timeout, plus some
+// time to disconnect from MongoDB.
+func (spec ServerSpec) GracePeriodSeconds() int {
+	timeout, _ := time.ParseDuration(spec.ShutdownTimeout)
+	return int(math.Ceil(timeout.Seconds())) + 5
+}
+
+// KubernetesSpec stands for the settings of the Kubernetes manifests
+// and the Helm chart.
+type KubernetesSpec struct {
+	// Enabled tells whether the manifests are generated (in k8s/).
+	Enabled bool `yaml:"enabled"`
+	// Helm tells whether a Helm chart is generated (in helm/). Its
+	// default values are these settings.
+	Helm bool `yaml:"helm"`
+	// Namespace is the namespace to deploy the stack into.
+	Namespace string `yaml:"namespace"`
+	// Image is the image of the server, which must be built and
+	// pushed to a registry the cluster can pull from.
+	Image string `yaml:"image"`
+	// Replicas is the number of replicas of the server.
+	Replicas uint16 `yaml:"replicas"`
+	// StorageSize is the size of the MongoDB volume (e.g. 1Gi).
+	StorageSize string `yaml:"storageSize"`
+	// IngressHost is the host to route to the server through an
+	// Ingress. It is optional: without it, no Ingress is generated.
+	IngressHost string `yaml:"ingressHost,omitempty"`
+}
+
+// Deployment profiles: what the generated stack is suitable for.
+const (
+	// profileDevelopment exposes everything (Mongo, Mongo Express)
+	// to the host, and runs the server in debug mode.
+	profileDevelopment = "development"
+	// profileProduction only exposes the HTTP server, and hardens the
+	// services (healthchecks, limits, read-only filesystems, non-root
+	// server).
+	profileProduction = "production"
+)
+
+// ProjectSpec is the whole parameter model of a generated project.
+// Every generated file is rendered against an instance of this type.
+type ProjectSpec struct {
+	// Template is the app template to use: a builtin one (e.g.
+	// "default:simple"), an installed one (e.g. "local:rpg") or a
+	// path to a file, template pack directory or archive.
+	Template string `yaml:"template"`
+	// Parameters are the values of the template's parameters. The
+	// parameters not given take their default values.
+	Parameters map[string]string `yaml:"parameters,omitempty"`
+	// Profile is the deployment profile: development or production.
+	Profile string `yaml:"profile"`
+	// Schema is the path to the schema file the resources are generated
+	// from. It is optional.
+	Schema string `yaml:"schema,omitempty"`
+	// Mongo holds the MongoDB settings.
+	Mongo MongoSpec `yaml:"mongo"`
+	// MongoExpress holds the Mongo Express settings.
+	MongoExpress MongoExpressSpec `yaml:"mongoExpress"`
+	// HTTP holds the HTTP service settings.
+	HTTP HTTPSpec `yaml:"http"`
+	// TLS holds the TLS reverse proxy settings.
+	TLS TLSSpec `yaml:"tls"`
+	// Metrics holds the metrics and monitoring settings.
+	Metrics MetricsSpec `yaml:"metrics"`
+	// Server holds the server app settings.
+	Server ServerSpec `yaml:"server"`
+	// Kubernetes holds the Kubernetes manifests and Helm chart settings.
+	Kubernetes KubernetesSpec `yaml:"kubernetes"`
+}
+
+// defaultProjectSpec returns the spec with all the default values,
+// save for the template (which must always be chosen) and the secrets
+// (which are randomly generated when not given).
+func defaultProjectSpec() *ProjectSpec {
+	return &ProjectSpec{
+		Profile: profileDevelopment,
+		Mongo: MongoSpec{
+			Port: 27017,
+			User: "admin",
+			ReplicaSet: ReplicaSetSpec{
+				Name: "rs0",
+			},
+		},
+		MongoExpress: MongoExpressSpec{
+			Port: 8081,
+		},
+		HTTP: HTTPSpec{
+			Port: 8080,
+		},
+		TLS: TLSSpec{
+			Port: 8443,
+			Host: "localhost",
+		},
+		Metrics: MetricsSpec{
+			PrometheusPort: 9090,
+			GrafanaPort:    3000,
+		},
+		Server: ServerSpec{
+			Module:          "my-project",
+			GoVersion:       "1.22",
+			StorageVersion:  "v1.3.2",
+			Dependencies:    dependenciesTidy,
+			ShutdownTimeout: "15s",
+		},
+		Kubernetes: KubernetesSpec{
+			Namespace:   "windrose",
+			Image:       "windrose-server:latest",
+			Replicas:    1,
+			StorageSize: "1Gi",
+		},
+	}
+}
+
+var (
+	// modulePathRegex matches the valid module paths: slash-separated
+	// elements, none of them starting or ending with a dot.
+	modulePathRegex = regexp.MustCompile(`^[a-zA-Z0-9_~-]+([.-][a-zA-Z0-9_~]+)*(/[a-zA-Z0-9_~-]+([.-][a-zA-Z0-9_~]+)*)*$`)
+	// goVersionRegex matches the Go versions (e.g. 1.22 or 1.22.3).
+	goVersionRegex = regexp.MustCompile(`^1\.[0-9]+(\.[0-9]+)?$`)
+	// dnsLabelRegex matches the valid DNS labels (e.g. namespaces).
+	dnsLabelRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+	// hostRegex matches the valid host names, optionally wildcards.
+	hostRegex = regexp.MustCompile(`^(\*\.)?[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+	// quantityRegex matches the storage quantities (e.g. 1Gi).
+	quantityRegex = regexp.MustCompile(`^[0-9]+(Ki|Mi|Gi|Ti|Pi|k|M|G|T|P)?$`)
+	// semverRegex matches the semantic versions of modules (e.g. v1.3.2).
+	semverRegex = regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+)
+
+// ServerDebug tells whether the rendered app runs in debug mode: as
+// set in the spec, or else unless in the production profile.
+func (spec *ProjectSpec) ServerDebug() bool {
+	if spec.Server.Debug != nil {
+		return *spec.Server.Debug
+	}
+	return spec.Profile != profileProduction
+}
+
+// validate tells whether the spec is valid, for the settings which
+// cannot be validated by the generated files themselves.
+func (spec *ProjectSpec) validate() error {
+	if spec.Profile != profileDevelopment && spec.Profile != profileProduction {
+		return fmt.Errorf("%w: invalid profile (expected %s or %s): %q", ErrInvalidSpec, profileDevelopment, profileProduction, spec.Profile)
+	}
+	if spec.Profile == profileProduction && spec.ServerDebug() {
+		return fmt.Errorf("%w: the server cannot run in debug mode in the %s profile", ErrInvalidSpec, profileProduction)
+	}
+	if members := spec.Mongo.ReplicaSet.Members; members != 0 && members != 1 && members != 3 {
+		return fmt.Errorf("%w: invalid number of replica set members (expected 0, 1 or 3): %d", ErrInvalidSpec, members)
+	}
+	if spec.Mongo.ReplicaSet.Members != 0 && !dnsLabelRegex.MatchString(spec.Mongo.ReplicaSet.Name) {
+		return fmt.Errorf("%w: invalid replica set name: %q", ErrInvalidSpec, spec.Mongo.ReplicaSet.Name)
+	}
+	if spec.TLS.Enabled {
+		if !hostRegex.MatchString(spec.TLS.Host) {
+			return fmt.Errorf("%w: invalid TLS host: %q", ErrInvalidSpec, spec.TLS.Host)
+		}
+		if (spec.TLS.CertFile == "") != (spec.TLS.KeyFile == "") {
+			return fmt.Errorf("%w: the TLS certificate and key files must be given together", ErrInvalidSpec)
+		}
+		if spec.Profile == profileProduction && spec.TLS.CertFile == "" {
+			return fmt.Errorf("%w: the %s profile needs a TLS certificate and key (self-signed ones are only for development)", ErrInvalidSpec, profileProduction)
+		}
+	}
+	if spec.Metrics.Services && !spec.Metrics.Enabled {
+		return fmt.Errorf("%w: the monitoring services need the server's metrics to be enabled", ErrInvalidSpec)
+	}
+	if !modulePathRegex.MatchString(spec.Server.Module) {
+		return fmt.Errorf("%w: invalid module path: %q", ErrInvalidSpec, spec.Server.Module)
+	}
+	if !goVersionRegex.MatchString(spec.Server.GoVersion) {
+		return fmt.Errorf("%w: invalid Go version (expected e.g. 1.22 or 1.22.3): %q", ErrInvalidSpec, spec.Server.GoVersion)
+	}
+	if !semverRegex.MatchString(spec.Server.StorageVersion) {
+		return fmt.Errorf("%w: invalid storage library version (expected e.g. v1.3.2): %q", ErrInvalidSpec, spec.Server.StorageVersion)
+	}
+	if spec.Kubernetes.Enabled || spec.Kubernetes.Helm {
+		if !dnsLabelRegex.MatchString(spec.Kubernetes.Namespace) {
+			return fmt.Errorf("%w: invalid Kubernetes namespace: %q", ErrInvalidSpec, spec.Kubernetes.Namespace)
+		}
+		if spec.Kubernetes.Image == "" || strings.ContainsAny(spec.Kubernetes.Image, " \t\n") {
+			return fmt.Errorf("%w: invalid server image: %q", ErrInvalidSpec, spec.Kubernetes.Image)
+		}
+		if !quantityRegex.MatchString(spec.Kubernetes.StorageSize) {
+			return fmt.Errorf("%w: invalid MongoDB storage size (expected e.g. 1Gi): %q", ErrInvalidSpec, spec.Kubernetes.StorageSize)
+		}
+		if spec.Kubernetes.IngressHost != "" && !hostRegex.MatchString(spec.Kubernetes.IngressHost) {
+			return fmt.Errorf("%w: invalid ingress host: %q", ErrInvalidSpec, spec.Kubernetes.IngressHost)
+		}
+		// The manifests only run a single, standalone MongoDB.
+		if spec.Mongo.ReplicaSet.Members != 0 {
+			return fmt.Errorf("%w: the Kubernetes manifests and Helm chart do not support a MongoDB replica set yet", ErrInvalidSpec)
+		}
+	}
+	if timeout, err := time.ParseDuration(spec.Server.ShutdownTimeout); err != nil || timeout <= 0 {
+		return fmt.Errorf("%w: invalid shutdown timeout (expected e.g. 15s): %q", ErrInvalidSpec, spec.Server.ShutdownTimeout)
+	}
+	if !slices.Contains(dependencyModes, spec.Server.Dependencies) {
+		return fmt.Errorf("%w: invalid dependencies mode (expected one of %s): %q", ErrInvalidSpec, strings.Join(dependencyModes, ", "), spec.Server.Dependencies)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package templates
+
+import (
+	_ "embed"
+)
+
+// The app templates are plain Go sources, embedded and copied as they
+// are, so they can contain any character. Their settings come from the
+// environment.
+var (
+	//go:embed simple.go.tmpl
+	SimpleAppTemplate string
+	//go:embed multichar.go.tmpl
+	MultipleAppTemplates string
+	//go:embed empty.go.tmpl
+	EmptyAppTemplate string
+)
+
+// Template describes one of the builtin app templates.
+type Template struct {
+	// Key is the value to pass as template to use this one.
+	Key string
+	// Description is a short human-readable description.
+	Description string
+	// Resources are the names of the resources the template defines.
+	Resources []string
+	// Contents is the source of the app template.
+	Contents string
+}
+
+// Builtin lists all the builtin app templates.
+var Builtin = []Template{
+	{
+		Key:         "default:simple",
+		Description: "Accounts with a single embedded position, plus scopes and maps",
+		Resources:   []string{"accounts", "scopes", "maps"},
+		Contents:    SimpleAppTemplate,
+	},
+	{
+		Key:         "default:multichar",
+		Description: "Accounts owning multiple characters, plus scopes and maps",
+		Resources:   []string{"accounts", "characters", "scopes", "maps"},
+		Contents:    MultipleAppTemplates,
+	},
+	{
+		Key:         "default:empty",
+		Description: "No resources: they come from the schema or other files",
+		Contents:    EmptyAppTemplate,
+	},
+}
+
+// FindBuiltin looks for a builtin template by its key.
+func FindBuiltin(key string) (Template, bool) {
+	for _, template := range Builtin {
+		if template.Key == key {
+			return template, true
+		}
+	}
+	return Template{}, false
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// writeMode tells how to deal with existing files that would change.
+type writeMode int
+
+const (
+	// writeSafe refuses to overwrite existing files that would change.
+	writeSafe writeMode = iota
+	// writeForce overwrites existing files that would change.
+	writeForce
+	// writeBackup overwrites existing files that would change, keeping
+	// a backup of each one.
+	writeBackup
+)
+
+// writeOptions tells how to apply a plan.
+type writeOptions struct {
+	// mode tells how to deal with existing files that would change.
+	mode writeMode
+	// dryRun tells to print the plan instead of writing it.
+	dryRun bool
+	// showContents tells to print the files' contents in a dry run.
+	showContents bool
+	// skipCheck tells not to compile-check the server before writing.
+	skipCheck bool
+	// secrets are the secrets to redact from the printed contents and
+	// diffs. The ones in the project's .env file are redacted too.
+	secrets []string
+}
+
+// plannedFile is a file to generate, relative to the project.
+type plannedFile struct {
+	path     string
+	contents string
+	mode     os.FileMode
+}
+
+// filePlan is the ordered list of files to generate.
+type filePlan struct {
+	files []plannedFile
+}
+
+// has tells whether a file is already planned.
+func (plan *filePlan) has(path string) bool {
+	for _, file := range plan.files {
+		if file.path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// add adds a file to the plan, replacing any previous one in the same path.
+func (plan *filePlan) add(path, contents string, mode os.FileMode) {
+	for index := range plan.files {
+		if plan.files[index].path == path {
+			plan.files[index] = plannedFile{path, contents, mode}
+			return
+		}
+	}
+	plan.files = append(plan.files, plannedFile{path, contents, mode})
+}
+
+// render renders a template against the given data, and adds the
+// result to the plan.
+func (plan *filePlan) render(path, text string, data any, mode os.FileMode) error {
+	contents, err := renderTemplate(filepath.ToSlash(path), text, data)
+	if err != nil {
+		return err
+	}
+	plan.add(path, contents, mode)
+	return nil
+}
+
+// fileStatus tells how a planned file relates to the existing one.
+func fileStatus(projectPath string, file plannedFile) string {
+	content, err := os.ReadFile(filepath.Join(projectPath, file.path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "new"
+		}
+		return "unreadable"
+	} else if string(content) == file.contents {
+		return "unchanged"
+	}
+	return "changed"
+}
+
+// planNode is a node (directory or file) of the tree of planned files.
+type planNode struct {
+	name     string
+	file     *plannedFile
+	children []*planNode
+}
+
+// child gets or creates a child node by its name.
+func (node *planNode) child(name string) *planNode {
+	for _, child := range node.children {
+		if child.name == name {
+			return child
+		}
+	}
+	child := &planNode{name: name}
+	node.children = append(node.children, child)
+	return child
+}
+
+// printTree prints the children of a node, recursively.
+func printTree(writer io.Writer, projectPath string, node *planNode, indent string) {
+	for index, child := range node.children {
+		branch, nextIndent := "├── ", indent+"│   "
+		if index == len(node.children)-1 {
+			branch, nextIndent = "└── ", indent+"    "
+		}
+		if child.file != nil {
+			_, _ = fmt.Fprintf(writer, "%s%s%s [%s, %04o]\n", indent, branch, child.name, fileStatus(projectPath, *child.file), child.file.mode)
+		} else {
+			_, _ = fmt.Fprintf(writer, "%s%s%s/\n", indent, branch, child.name)
+			printTree(writer, projectPath, child, nextIndent)
+		}
+	}
+}
+
+// printPlan prints the tree of planned files and their status and,
+// optionally, their contents, with the given secrets redacted. Nothing
+// is written.
+func printPlan(writer io.Writer, projectPath string, plan *filePlan, showContents bool, secrets []string) {
+	files := slices.Clone(plan.files)
+	sort.Slice(files, func(i, j int) bool {
+		return filepath.ToSlash(files[i].path) < filepath.ToSlash(files[j].path)
+	})
+
+	root := &planNode{}
+	for index := range files {
+		node := root
+		for _, part := range strings.Split(filepath.ToSlash(files[index].path), "/") {
+			node = node.child(part)
+		}
+		node.file = &files[index]
+	}
+	_, _ = fmt.Fprintln(writer, projectPath+"/")
+	printTree(writer, projectPath, root, "")
+
+	if showContents {
+		for _, file := range files {
+			_, _ = fmt.Fprintf(writer, "\n==> %s <==\n%s\n", filepath.ToSlash(file.path), redactSecrets(file.contents, secrets))
+		}
+	}
+}
+
+// existingFile is a file that existed before applying a plan.
+type existingFile struct {
+	path     string
+	contents string
+	mode     os.FileMode
+}
+
+// writeTransaction keeps track of the changes made while writing the
+// files of a plan, so they can be rolled back on failure.
+type writeTransaction struct {
+	createdDirs  []string
+	createdFiles []string
+	overwritten  []existingFile
+}
+
+// mkdirAll creates a directory and its missing parents, keeping track
+// of the ones it created.
+func (transaction *writeTransaction) mkdirAll(dir string) error {
+	missing := []string{}
+	for current := dir; ; current = filepath.Dir(current) {
+		if _, err := os.Stat(current); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return &PathError{"access directory", current, err}
+		}
+		missing = append(missing, current)
+		if filepath.Dir(current) == current {
+			break
+		}
+	}
+	for index := len(missing) - 1; index >= 0; index-- {
+		if err := os.Mkdir(missing[index], 0755); err != nil {
+			return &PathError{"create directory", missing[index], err}
+		}
+		transaction.createdDirs = append(transaction.createdDirs, missing[index])
+	}
+	return nil
+}
+
+// createFile creates a new file, keeping track of it.
+func (transaction *writeTransaction) createFile(filePath, contents string, mode os.FileMode) error {
+	if err := dumpFile(filePath, contents, mode); err != nil {
+		return err
+	}
+	transaction.createdFiles = append(transaction.createdFiles, filePath)
+	return nil
+}
+
+// stageFile writes the contents of a file into a temporary file next
+// to it, and returns the temporary file's path.
+func (transaction *writeTransaction) stageFile(filePath, contents string, mode os.FileMode) (string, error) {
+	file, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".staging-*")
+	if err != nil {
+		return "", &PathError{"stage", filePath, err}
+	}
+	transaction.createdFiles = append(transaction.createdFiles, file.Name())
+	_, err = file.WriteString(contents)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), mode)
+	}
+	if err != nil {
+		return "", &PathError{"stage", filePath, err}
+	}
+	return file.Name(), nil
+}
+
+// rollback undoes all the tracked changes, as much as possible.
+func (transaction *writeTransaction) rollback() {
+	for _, file := range transaction.overwritten {
+		if err := dumpFile(file.path, file.contents, file.mode); err == nil {
+			_ = os.Chmod(file.path, file.mode)
+		} else {
+			_, _ = fmt.Fprintln(os.Stderr, "Could not restore "+file.path+":", err)
+		}
+	}
+	for index := len(transaction.createdFiles) - 1; index >= 0; index-- {
+		_ = os.Remove(transaction.createdFiles[index])
+	}
+	for index := len(transaction.createdDirs) - 1; index >= 0; index-- {
+		_ = os.Remove(transaction.createdDirs[index])
+	}
+}
+
+// writeNewProject writes the files of a project that does not exist
+// yet. They are written into a staging directory which is then moved
+// into place at once, so no partial project is ever left behind.
+func writeNewProject(projectPath string, files []plannedFile) (err error) {
+	transaction := &writeTransaction{}
+	defer func() {
+		if err != nil {
+			transaction.rollback()
+		}
+	}()
+
+	// A trailing slash would make the staging directory a child of the
+	// project, instead of a sibling.
+	projectPath = filepath.Clean(projectPath)
+	parent := filepath.Dir(projectPath)
+	if err := transaction.mkdirAll(parent); err != nil {
+		return err
+	}
+	staging, err := os.MkdirTemp(parent, "."+filepath.Base(projectPath)+".staging-")
+	if err != nil {
+		return &PathError{"create staging directory", parent, err}
+	}
+	// Once moved into place, the staging directory does not exist anymore.
+	defer os.RemoveAll(staging)
+
+	for _, file := range files {
+		filePath := filepath.Join(staging, file.path)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return &PathError{"create directory", filepath.Dir(filePath), err}
+		}
+		if err := dumpFile(filePath, file.contents, file.mode); err != nil {
+			return err
+		}
+	}
+	if err := os.Chmod(staging, 0755); err != nil {
+		return &PathError{"set permissions of", staging, err}
+	}
+	if err := os.Rename(staging, projectPath); err != nil {
+		return &PathError{"move staged project into", projectPath, err}
+	}
+	return nil
+}
+
+// writeIntoProject writes the files into an existing project. Each one
+// is staged next to its target, and then moved into place. If anything
+// fails, the previous state of the project is restored.
+func writeIntoProject(projectPath string, files []plannedFile, existing map[string]existingFile, mode writeMode) (err error) {
+	transaction := &writeTransaction{}
+	defer func() {
+		if err != nil {
+			transaction.rollback()
+		}
+	}()
+
+	// First, stage every file.
+	staged := make([]string, len(files))
+	for index, file := range files {
+		filePath := filepath.Join(projectPath, file.path)
+		if err := transaction.mkdirAll(filepath.Dir(filePath)); err != nil {
+			return err
+		}
+		if staged[index], err = transaction.stageFile(filePath, file.contents, file.mode); err != nil {
+			return err
+		}
+	}
+
+	// Then, move them into place (backing up the existing ones, if told).
+	backupSuffix := "." + time.Now().Format("20060102-150405") + ".bak"
+	messages := []string{}
+	for index, file := range files {
+		filePath := filepath.Join(projectPath, file.path)
+		original, exists := existing[file.path]
+		if exists && mode == writeBackup {
+			if err := transaction.createFile(filePath+backupSuffix, original.contents, 0600); err != nil {
+				return err
+			}
+			messages = append(messages, "Backed up "+file.path+" into "+file.path+backupSuffix)
+		}
+		if err := os.Rename(staged[index], filePath); err != nil {
+			return &PathError{"move staged file into", filePath, err}
+		}
+		if exists {
+			transaction.overwritten = append(transaction.overwritten, original)
+			messages = append(messages, "Overwritten "+file.path)
+		} else {
+			transaction.createdFiles = append(transaction.createdFiles, filePath)
+		}
+	}
+
+	for _, message := range messages {
+		fmt.Println(message)
+	}
+	return nil
+}
+
+// applyPlan writes the planned files into the project (or just prints
+// them, on dry runs), once the app calls serve and the server
+// compile-checks. Unchanged files are not touched. When existing files
+// would change, their diff is printed and nothing is written, unless
+// the mode allows it. Writing is all-or-nothing: on failure, the
+// project is left as it was.
+func applyPlan(projectPath string, plan *filePlan, options writeOptions) error {
+	if err := checkServeCalls(projectPath, plan); err != nil {
+		return err
+	}
+	if !options.skipCheck {
+		if err := checkServer(projectPath, plan); err != nil {
+			return err
+		}
+	}
+	// The secrets of the project, if it exists, are redacted too.
+	existingSecrets := &ProjectSpec{}
+	if err := loadEnvSecrets(projectPath, existingSecrets); err != nil {
+		return err
+	}
+	secrets := append(slices.Clone(options.secrets), secretValues(existingSecrets)...)
+	if options.dryRun {
+		printPlan(os.Stdout, projectPath, plan, options.showContents, secrets)
+		return nil
+	}
+
+	// First, compare against the existing files.
+	existing := map[string]existingFile{}
+	pending := []plannedFile{}
+	changed := []plannedFile{}
+	for _, file := range plan.files {
+		filePath := filepath.Join(projectPath, file.path)
+		content, err := os.ReadFile(filePath)
+		if err == nil {
+			stat, err := os.Stat(filePath)
+			if err != nil {
+				return &PathError{"access existing file", filePath, err}
+			}
+			existing[file.path] = existingFile{filePath, string(content), stat.Mode().Perm()}
+			if string(content) != file.contents {
+				changed = append(changed, file)
+				pending = append(pending, file)
+			}
+		} else if os.IsNotExist(err) {
+			pending = append(pending, file)
+		} else {
+			return &PathError{"read existing file", filePath, err}
+		}
+	}
+	if len(changed) > 0 && options.mode == writeSafe {
+		for _, file := range changed {
+			_, _ = fmt.Fprint(os.Stderr, redactSecrets(unifiedDiff(
+				filepath.ToSlash(filepath.Join("a", file.path)), filepath.ToSlash(filepath.Join("b", file.path)),
+				existing[file.path].contents, file.contents,
+			), secrets))
+		}
+		return fmt.Errorf(
+			"%w: %d existing file(s) in %s would be overwritten (see the diff above); use -force to overwrite them or -backup to keep a copy",
+			ErrPathExists, len(changed), projectPath,
+		)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// Then, write the new and changed files.
+	if _, err := os.Stat(projectPath); os.IsNotExist(err) {
+		return writeNewProject(projectPath, pending)
+	} else if err != nil {
+		return &PathError{"access project directory", projectPath, err}
+	}
+	return writeIntoProject(projectPath, pending, existing, options.mode)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteNewProject(t *testing.T) {
+	files := []plannedFile{
+		{"docker-compose.yml", "services: {}\n", 0644},
+		{filepath.Join("server", "main.go"), "package main\n", 0644},
+		{"compose.sh", "#!/bin/sh\n", 0755},
+	}
+	for _, projectPath := range []string{"project", "project/", "nested/project", "nested/project/"} {
+		t.Run(projectPath, func(t *testing.T) {
+			root := t.TempDir()
+			// Not joined, since joining would clean the trailing slash.
+			if err := writeNewProject(root+string(filepath.Separator)+filepath.FromSlash(projectPath), files); err != nil {
+				t.Fatalf("writeNewProject: %v", err)
+			}
+			for _, file := range files {
+				filePath := filepath.Join(root, projectPath, file.path)
+				content, err := os.ReadFile(filePath)
+				if err != nil {
+					t.Fatalf("read %s: %v", file.path, err)
+				}
+				if string(content) != file.contents {
+					t.Errorf("%s: got %q, want %q", file.path, content, file.contents)
+				}
+				if stat, err := os.Stat(filePath); err != nil || stat.Mode().Perm() != file.mode {
+					t.Errorf("%s: wrong mode (%v)", file.path, err)
+				}
+			}
+			entries, err := os.ReadDir(filepath.Dir(filepath.Join(root, filepath.Clean(projectPath))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("staging directory left behind: %d entries next to the project", len(entries))
+			}
+		})
+	}
+}
+
+func TestWriteIntoProjectRollback(t *testing.T) {
+	cases := []struct {
+		name  string
+		files []plannedFile
+	}{
+		{"file over a directory", []plannedFile{
+			{"existing.txt", "changed\n", 0644},
+			{"new.txt", "new\n", 0644},
+			{"server", "not a directory\n", 0644},
+		}},
+		{"directory over a file", []plannedFile{
+			{"existing.txt", "changed\n", 0644},
+			{filepath.Join("existing.txt", "child.txt"), "child\n", 0644},
+		}},
+	}
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			projectPath := t.TempDir()
+			existingPath := filepath.Join(projectPath, "existing.txt")
+			if err := os.WriteFile(existingPath, []byte("original\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Mkdir(filepath.Join(projectPath, "server"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			existing := map[string]existingFile{
+				"existing.txt": {existingPath, "original\n", 0644},
+			}
+
+			if err := writeIntoProject(projectPath, testCase.files, existing, writeForce); err == nil {
+				t.Fatal("writeIntoProject: expected an error")
+			}
+			if content, _ := os.ReadFile(existingPath); string(content) != "original\n" {
+				t.Errorf("existing.txt was not restored: %q", content)
+			}
+			entries, err := os.ReadDir(projectPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 2 {
+				names := []string{}
+				for _, entry := range entries {
+					names = append(names, entry.Name())
+				}
+				t.Errorf("files left behind: %v", names)
+			}
+		})
+	}
+}
+
+func TestApplyPlan(t *testing.T) {
+	cases := []struct {
+		name    string
+		mode    writeMode
+		dryRun  bool
+		want    string
+		wantErr error
+	}{
+		{"safe", writeSafe, false, "original\n", ErrPathExists},
+		{"force", writeForce, false, "changed\n", nil},
+		{"backup", writeBackup, false, "changed\n", nil},
+		{"dry run", writeForce, true, "original\n", nil},
+	}
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			projectPath := t.TempDir()
+			existingPath := filepath.Join(projectPath, "existing.txt")
+			if err := os.WriteFile(existingPath, []byte("original\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			plan := &filePlan{}
+			plan.add("existing.txt", "changed\n", 0644)
+			plan.add("new.txt", "new\n", 0644)
+
+			err := applyPlan(projectPath, plan, writeOptions{mode: testCase.mode, dryRun: testCase.dryRun, skipCheck: true})
+			if !errors.Is(err, testCase.wantErr) {
+				t.Fatalf("applyPlan: got %v, want %v", err, testCase.wantErr)
+			}
+			if content, _ := os.ReadFile(existingPath); string(content) != testCase.want {
+				t.Errorf("existing.txt: got %q, want %q", content, testCase.want)
+			}
+			_, err = os.Stat(filepath.Join(projectPath, "new.txt"))
+			if written := err == nil; written != (testCase.wantErr == nil && !testCase.dryRun) {
+				t.Errorf("new.txt written: %v", written)
+			}
+			backups, _ := filepath.Glob(existingPath + ".*.bak")
+			if (len(backups) == 1) != (testCase.mode == writeBackup) {
+				t.Errorf("backups: %v", backups)
+			}
+		})
+	}
+}
@@ -1,3 +1,5 @@
 module github.com/AlephVault/golang-windrose-http-storage-generator
 
 go 1.22.0
+
+require gopkg.in/yaml.v3 v3.0.1